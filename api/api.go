@@ -6,8 +6,10 @@ import (
 	"Fortune_Tracker_API/api/user"
 	"Fortune_Tracker_API/config"
 	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/oidc"
 	"Fortune_Tracker_API/internal/validator"
 	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mailer"
 	"Fortune_Tracker_API/pkg/mariadb"
 	"Fortune_Tracker_API/pkg/mongodb"
 	"io"
@@ -27,9 +29,19 @@ func Main() {
 	// Create gin router
 	r := gin.Default()
 
+	// Public keys of the JWT signing keys
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+
 	// Users (no token validation)
 	r.POST("/user", user.Register)
 	r.POST("/user/login", user.Login)
+	r.POST("/user/login/2fa", user.LoginTwoFactor)
+	r.POST("/user/token/refresh", user.RefreshToken)
+	r.POST("/user/password/forgot", user.ForgotPassword)
+	r.POST("/user/password/reset", user.ResetPassword)
+	r.POST("/user/email/verify", user.VerifyEmail)
+	r.GET("/user/oidc/:provider/login", user.StartOIDCLogin)
+	r.POST("/user/oidc/:provider/callback", user.FinishOIDCLogin)
 
 	// Auth middleware for all routes below
 	r.Use(auth.ValidateToken)
@@ -37,28 +49,73 @@ func Main() {
 	// Users
 	r.GET("/user/:uuid", user.Get)
 	r.PUT("/user/", user.Update)
+	r.DELETE("/user", user.Delete)
+	r.PUT("/user/password", user.ChangePassword)
+	r.POST("/user/logout", user.Logout)
+	r.POST("/user/logout/all", user.LogoutAll)
+	r.GET("/user/sessions", user.ListSessions)
+	r.DELETE("/user/sessions/:sid", user.RevokeSession)
+	r.POST("/user/email/verify/resend", user.ResendEmailVerification)
+	r.POST("/user/2fa/totp", user.EnrollTOTP)
+	r.POST("/user/2fa/totp/confirm", user.ConfirmTOTP)
+	r.DELETE("/user/2fa/totp", user.DisableTOTP)
+	r.POST("/user/tokens", user.CreateAPIToken)
+	r.GET("/user/tokens", user.ListAPITokens)
+	r.DELETE("/user/tokens/:atid", user.RevokeAPIToken)
+	r.GET("/user/invitations", user.GetInvitations)
+	r.POST("/user/invitations/:code/accept", user.AcceptInvitation)
+	r.POST("/user/invitations/:code/decline", user.DeclineInvitation)
 
 	// Ledger
 	r.GET("/ledger", ledger.Get)
 	r.POST("/ledger", ledger.Create)
+	r.GET("/ledger/template", ledger.GetTemplates)
+	r.DELETE("/ledger/template/:tpid", ledger.DeleteTemplate)
 
 	ledgerRoutes := r.Group("/ledger/:ulid")
-	ledgerRoutes.Use(validator.ValidateULIDParam)
+	ledgerRoutes.Use(validator.ValidateULIDParam, ledger.CheckMembership, ledger.CheckPermission)
 	{
 		// ledger info
 		ledgerRoutes.PATCH("/", ledger.Update)
+		ledgerRoutes.DELETE("/", ledger.Delete)
+		ledgerRoutes.POST("/archive", ledger.Archive)
+		ledgerRoutes.POST("/restore", ledger.Restore)
 
 		// Ledger members
-		ledgerRoutes.POST("/member", ledger.AddMember)
+		ledgerRoutes.POST("/invitation", ledger.CreateInvitation)
+		ledgerRoutes.GET("/invitation", ledger.GetInvitations)
+		ledgerRoutes.DELETE("/invitation/:code", ledger.RevokeInvitation)
 		ledgerRoutes.PATCH("/member", ledger.UpdateNickname)
+		ledgerRoutes.PUT("/member/:uuid/role", ledger.UpdateRole)
 		ledgerRoutes.DELETE("/member", ledger.RemoveMember)
+		ledgerRoutes.DELETE("/member/:uuid", ledger.RemoveOtherMember)
+		ledgerRoutes.POST("/owner", ledger.TransferOwnership)
+
+		// Ledger categories
+		ledgerRoutes.GET("/types", ledger.GetTypes)
+		ledgerRoutes.POST("/types", ledger.CreateParentType)
+		ledgerRoutes.PUT("/types/order", ledger.ReorderParentTypes)
+		ledgerRoutes.PATCH("/types/:ptid", ledger.UpdateParentType)
+		ledgerRoutes.DELETE("/types/:ptid", ledger.DeleteParentType)
+		ledgerRoutes.POST("/types/:ptid/child", ledger.CreateChildType)
+		ledgerRoutes.PUT("/types/:ptid/child/order", ledger.ReorderChildTypes)
+		ledgerRoutes.PATCH("/types/:ptid/child/:ctid", ledger.UpdateChildType)
+		ledgerRoutes.DELETE("/types/:ptid/child/:ctid", ledger.DeleteChildType)
+
+		ledgerRoutes.POST("/template", ledger.SaveTemplate)
 
 		// Ledger transactions
 		ledgerRoutes.POST("/transaction", transaction.Create)
 		ledgerRoutes.DELETE("/transaction/:utid", transaction.Delete)
 		ledgerRoutes.GET("/transaction/:utid", transaction.Get)
 		ledgerRoutes.GET("/transaction/time", transaction.GetByTime)
+		ledgerRoutes.GET("/transaction/consistency", transaction.GetInconsistent)
 		ledgerRoutes.PUT("/transaction/:utid", transaction.Update)
+
+		// Ledger balances
+		ledgerRoutes.GET("/balances", transaction.GetBalances)
+		ledgerRoutes.GET("/settle-up", transaction.GetSettleUp)
+		ledgerRoutes.POST("/settlement", transaction.CreateSettlement)
 	}
 
 	// Start API service
@@ -91,13 +148,20 @@ func Main() {
 }
 
 func apiInit() {
-	config.LoadConfig() // Load config
-	auth.SetJWTKey()    // Set JWT key
-	logger.InitLogger() // Init logger
-	ginInit()           // Init gin
+	config.LoadConfig()  // Load config
+	logger.InitLogger()  // Init logger
+	mailer.InitMailer()  // Init mail sender
+	oidc.LoadProviders() // Load OpenID Connect providers
+	ginInit()            // Init gin
 
-	// Connect to MariaDB
+	// Load JWT keys, tokens can not be signed or checked without them
 	var err error
+	if err = auth.LoadJWTKeys(); err != nil {
+		logger.Error("[AUTH] Error loading JWT keys: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Connect to MariaDB
 	if err = mariadb.Connect(); err != nil {
 		logger.Error("[MARIADB] " + err.Error())
 		return
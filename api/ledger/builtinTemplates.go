@@ -0,0 +1,173 @@
+package ledger
+
+// Category name in every supported locale
+type localizedName map[string]string
+
+type builtinChildType struct {
+	Name localizedName
+	Icon string
+}
+
+type builtinParentType struct {
+	Name       localizedName
+	Icon       string
+	Color      string
+	ChildTypes []builtinChildType
+}
+
+type builtinTemplate struct {
+	Name        localizedName
+	ParentTypes []builtinParentType
+}
+
+const defaultLocale = "en"
+
+var supportedLocales = map[string]bool{
+	"en":    true,
+	"zh-TW": true,
+}
+
+// Category templates every user can create a ledger from, keyed by the name given as Template
+var builtinTemplates = map[string]builtinTemplate{
+	"personal": {
+		Name: localizedName{"en": "Personal", "zh-TW": "個人"},
+		ParentTypes: []builtinParentType{
+			{Name: localizedName{"en": "Food", "zh-TW": "飲食"}, Icon: "restaurant", Color: "#F4A259", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Breakfast", "zh-TW": "早餐"}, Icon: "breakfast"},
+				{Name: localizedName{"en": "Lunch", "zh-TW": "午餐"}, Icon: "lunch"},
+				{Name: localizedName{"en": "Dinner", "zh-TW": "晚餐"}, Icon: "dinner"},
+				{Name: localizedName{"en": "Snacks", "zh-TW": "點心"}, Icon: "snack"},
+			}},
+			{Name: localizedName{"en": "Transportation", "zh-TW": "交通"}, Icon: "commute", Color: "#5B8E7D", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Public transport", "zh-TW": "大眾運輸"}, Icon: "train"},
+				{Name: localizedName{"en": "Taxi", "zh-TW": "計程車"}, Icon: "taxi"},
+				{Name: localizedName{"en": "Fuel", "zh-TW": "加油"}, Icon: "fuel"},
+			}},
+			{Name: localizedName{"en": "Shopping", "zh-TW": "購物"}, Icon: "shopping", Color: "#BC4B51", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Clothing", "zh-TW": "服飾"}, Icon: "clothing"},
+				{Name: localizedName{"en": "Electronics", "zh-TW": "3C"}, Icon: "devices"},
+				{Name: localizedName{"en": "Daily necessities", "zh-TW": "日用品"}, Icon: "basket"},
+			}},
+			{Name: localizedName{"en": "Entertainment", "zh-TW": "娛樂"}, Icon: "movie", Color: "#8CB369", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Movies", "zh-TW": "電影"}, Icon: "movie"},
+				{Name: localizedName{"en": "Games", "zh-TW": "遊戲"}, Icon: "game"},
+				{Name: localizedName{"en": "Subscriptions", "zh-TW": "訂閱"}, Icon: "subscription"},
+			}},
+			{Name: localizedName{"en": "Income", "zh-TW": "收入"}, Icon: "payments", Color: "#3D5A80", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Salary", "zh-TW": "薪水"}, Icon: "salary"},
+				{Name: localizedName{"en": "Bonus", "zh-TW": "獎金"}, Icon: "bonus"},
+				{Name: localizedName{"en": "Investment", "zh-TW": "投資"}, Icon: "investment"},
+			}},
+		},
+	},
+	"household": {
+		Name: localizedName{"en": "Household", "zh-TW": "家庭"},
+		ParentTypes: []builtinParentType{
+			{Name: localizedName{"en": "Groceries", "zh-TW": "生鮮雜貨"}, Icon: "grocery", Color: "#F4A259", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Food", "zh-TW": "食材"}, Icon: "food"},
+				{Name: localizedName{"en": "Household supplies", "zh-TW": "居家用品"}, Icon: "cleaning"},
+			}},
+			{Name: localizedName{"en": "Housing", "zh-TW": "居住"}, Icon: "home", Color: "#5B8E7D", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Rent", "zh-TW": "房租"}, Icon: "rent"},
+				{Name: localizedName{"en": "Mortgage", "zh-TW": "房貸"}, Icon: "mortgage"},
+				{Name: localizedName{"en": "Repairs", "zh-TW": "修繕"}, Icon: "repair"},
+			}},
+			{Name: localizedName{"en": "Utilities", "zh-TW": "水電瓦斯"}, Icon: "bolt", Color: "#BC4B51", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Electricity", "zh-TW": "電費"}, Icon: "electricity"},
+				{Name: localizedName{"en": "Water", "zh-TW": "水費"}, Icon: "water"},
+				{Name: localizedName{"en": "Gas", "zh-TW": "瓦斯費"}, Icon: "gas"},
+				{Name: localizedName{"en": "Internet", "zh-TW": "網路費"}, Icon: "wifi"},
+			}},
+			{Name: localizedName{"en": "Children", "zh-TW": "子女"}, Icon: "child", Color: "#8CB369", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Education", "zh-TW": "教育"}, Icon: "school"},
+				{Name: localizedName{"en": "Childcare", "zh-TW": "托育"}, Icon: "childcare"},
+			}},
+			{Name: localizedName{"en": "Health", "zh-TW": "醫療保健"}, Icon: "health", Color: "#3D5A80", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Medical", "zh-TW": "醫療"}, Icon: "medical"},
+				{Name: localizedName{"en": "Insurance", "zh-TW": "保險"}, Icon: "insurance"},
+			}},
+		},
+	},
+	"travel": {
+		Name: localizedName{"en": "Travel", "zh-TW": "旅遊"},
+		ParentTypes: []builtinParentType{
+			{Name: localizedName{"en": "Transportation", "zh-TW": "交通"}, Icon: "flight", Color: "#5B8E7D", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Flights", "zh-TW": "機票"}, Icon: "flight"},
+				{Name: localizedName{"en": "Trains", "zh-TW": "火車"}, Icon: "train"},
+				{Name: localizedName{"en": "Local transport", "zh-TW": "當地交通"}, Icon: "bus"},
+				{Name: localizedName{"en": "Car rental", "zh-TW": "租車"}, Icon: "car"},
+			}},
+			{Name: localizedName{"en": "Accommodation", "zh-TW": "住宿"}, Icon: "hotel", Color: "#3D5A80", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Hotel", "zh-TW": "飯店"}, Icon: "hotel"},
+				{Name: localizedName{"en": "Rental", "zh-TW": "民宿"}, Icon: "cottage"},
+			}},
+			{Name: localizedName{"en": "Food", "zh-TW": "飲食"}, Icon: "restaurant", Color: "#F4A259", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Restaurants", "zh-TW": "餐廳"}, Icon: "restaurant"},
+				{Name: localizedName{"en": "Snacks", "zh-TW": "小吃"}, Icon: "snack"},
+			}},
+			{Name: localizedName{"en": "Activities", "zh-TW": "活動"}, Icon: "attraction", Color: "#8CB369", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Tickets", "zh-TW": "門票"}, Icon: "ticket"},
+				{Name: localizedName{"en": "Tours", "zh-TW": "導覽行程"}, Icon: "tour"},
+			}},
+			{Name: localizedName{"en": "Shopping", "zh-TW": "購物"}, Icon: "shopping", Color: "#BC4B51", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Souvenirs", "zh-TW": "紀念品"}, Icon: "gift"},
+			}},
+		},
+	},
+	"business": {
+		Name: localizedName{"en": "Business", "zh-TW": "商務"},
+		ParentTypes: []builtinParentType{
+			{Name: localizedName{"en": "Revenue", "zh-TW": "營收"}, Icon: "payments", Color: "#3D5A80", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Sales", "zh-TW": "銷售"}, Icon: "sell"},
+				{Name: localizedName{"en": "Services", "zh-TW": "服務"}, Icon: "service"},
+			}},
+			{Name: localizedName{"en": "Operating expenses", "zh-TW": "營運費用"}, Icon: "business", Color: "#5B8E7D", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Rent", "zh-TW": "租金"}, Icon: "rent"},
+				{Name: localizedName{"en": "Utilities", "zh-TW": "水電費"}, Icon: "bolt"},
+				{Name: localizedName{"en": "Software", "zh-TW": "軟體"}, Icon: "software"},
+				{Name: localizedName{"en": "Office supplies", "zh-TW": "辦公用品"}, Icon: "supplies"},
+			}},
+			{Name: localizedName{"en": "Payroll", "zh-TW": "人事"}, Icon: "badge", Color: "#BC4B51", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Salaries", "zh-TW": "薪資"}, Icon: "salary"},
+				{Name: localizedName{"en": "Benefits", "zh-TW": "福利"}, Icon: "benefit"},
+			}},
+			{Name: localizedName{"en": "Travel", "zh-TW": "差旅"}, Icon: "flight", Color: "#F4A259", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Transportation", "zh-TW": "交通"}, Icon: "commute"},
+				{Name: localizedName{"en": "Lodging", "zh-TW": "住宿"}, Icon: "hotel"},
+				{Name: localizedName{"en": "Meals", "zh-TW": "餐費"}, Icon: "restaurant"},
+			}},
+			{Name: localizedName{"en": "Taxes and fees", "zh-TW": "稅費"}, Icon: "receipt", Color: "#8CB369", ChildTypes: []builtinChildType{
+				{Name: localizedName{"en": "Taxes", "zh-TW": "稅金"}, Icon: "tax"},
+				{Name: localizedName{"en": "Bank fees", "zh-TW": "銀行手續費"}, Icon: "bank"},
+			}},
+		},
+	},
+}
+
+// Name in the locale, falls back to the default locale
+func (n localizedName) in(locale string) string {
+	if name, ok := n[locale]; ok {
+		return name
+	}
+	return n[defaultLocale]
+}
+
+// Category tree of the template in the locale, PTIDs and CTIDs follow the template order
+func (t builtinTemplate) types(locale string) ledgerType {
+	lt := ledgerType{ParentTypes: []parentType{}, NextPTID: len(t.ParentTypes) + 1}
+	for i, bpt := range t.ParentTypes {
+		pt := parentType{
+			PTID:       i + 1,
+			Name:       bpt.Name.in(locale),
+			Icon:       bpt.Icon,
+			Color:      bpt.Color,
+			ChildTypes: []childType{},
+			NextCTID:   len(bpt.ChildTypes) + 1,
+		}
+		for j, bct := range bpt.ChildTypes {
+			pt.ChildTypes = append(pt.ChildTypes, childType{CTID: j + 1, Name: bct.Name.in(locale), Icon: bct.Icon})
+		}
+		lt.ParentTypes = append(lt.ParentTypes, pt)
+	}
+	return lt
+}
@@ -0,0 +1,320 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mailer"
+	"Fortune_Tracker_API/pkg/mongodb"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Invitation states
+const (
+	invitationPending  = "pending"
+	invitationAccepted = "accepted"
+	invitationDeclined = "declined"
+	invitationRevoked  = "revoked"
+)
+
+const (
+	defaultInvitationTTL = 7 * 24 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+)
+
+// An invitation to join a ledger, sent to an email or shared as a code / link (Email empty).
+// Every invitation can be accepted once.
+type Invitation struct {
+	Code        string `json:"Code" bson:"Code"`
+	ULID        string `json:"ULID" bson:"ULID"`
+	LedgerName  string `json:"LedgerName" bson:"LedgerName"`
+	InviterUUID string `json:"InviterUUID" bson:"InviterUUID"`
+	Email       string `json:"Email,omitempty" bson:"Email,omitempty"`
+	Role        string `json:"Role" bson:"Role"`
+	Status      string `json:"Status" bson:"Status"`
+	CreatedAt   int64  `json:"CreatedAt" bson:"CreatedAt"`
+	ExpiresAt   int64  `json:"ExpiresAt" bson:"ExpiresAt"`
+	RespondedAt int64  `json:"RespondedAt,omitempty" bson:"RespondedAt,omitempty"`
+	RespondedBy string `json:"RespondedBy,omitempty" bson:"RespondedBy,omitempty"`
+}
+
+func newInvitationCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func createInvitation(cir createInvitationRequest, l ledger, UUID string) (Invitation, error) {
+	var err error
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ttl := defaultInvitationTTL
+	if cir.ExpiresInHours != nil {
+		ttl = time.Duration(*cir.ExpiresInHours) * time.Hour
+	}
+
+	now := time.Now()
+	inv := Invitation{
+		ULID:        l.ULID,
+		LedgerName:  l.Name,
+		InviterUUID: UUID,
+		Email:       strings.ToLower(cir.Email),
+		Role:        cir.Role,
+		Status:      invitationPending,
+		CreatedAt:   now.Unix(),
+		ExpiresAt:   now.Add(ttl).Unix(),
+	}
+	if inv.Code, err = newInvitationCode(); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return Invitation{}, err
+	}
+
+	if _, err = mongodb.InvitationCollection.InsertOne(ctx, inv); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return Invitation{}, err
+	}
+
+	// Email invitations are also delivered by mail, a delivery failure is only logged
+	if inv.Email != "" {
+		body := "You have been invited to join the ledger \"" + l.Name + "\" on Fortune Tracker.\n\n" +
+			"Invitation code: " + inv.Code
+		if url := config.Viper.GetString("INVITATION_URL"); url != "" {
+			body += "\n\nOr open: " + url + "?code=" + inv.Code
+		}
+		_ = mailer.Send(inv.Email, "Invitation to a Fortune Tracker ledger", body)
+	}
+
+	logger.Info("[LEDGER] Created invitation to ledger: " + l.ULID)
+
+	return inv, nil
+}
+
+// List the pending invitations of a ledger
+func getLedgerInvitations(ULID string) ([]Invitation, error) {
+	invitations := []Invitation{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"ULID":      ULID,
+		"Status":    invitationPending,
+		"ExpiresAt": bson.M{"$gt": time.Now().Unix()},
+	}
+
+	cur, err := mongodb.InvitationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"CreatedAt": 1}))
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return invitations, err
+	}
+	if err = cur.All(ctx, &invitations); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return invitations, err
+	}
+
+	return invitations, nil
+}
+
+func revokeInvitation(ULID, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ULID": ULID, "Code": code, "Status": invitationPending}
+	update := bson.M{"$set": bson.M{"Status": invitationRevoked}}
+
+	result, err := mongodb.InvitationCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	} else if result.MatchedCount == 0 {
+		logger.Warn("[LEDGER] Invitation not found")
+		return errors.New("invitation not found")
+	}
+
+	logger.Info("[LEDGER] Revoked invitation to ledger: " + ULID)
+	return nil
+}
+
+// List the pending invitations sent to an email
+func GetPendingInvitations(email string) ([]Invitation, error) {
+	invitations := []Invitation{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"Email":     strings.ToLower(email),
+		"Status":    invitationPending,
+		"ExpiresAt": bson.M{"$gt": time.Now().Unix()},
+	}
+
+	cur, err := mongodb.InvitationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"CreatedAt": 1}))
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return invitations, err
+	}
+	if err = cur.All(ctx, &invitations); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return invitations, err
+	}
+
+	logger.Info("[LEDGER] Get pending invitations for: " + email)
+
+	return invitations, nil
+}
+
+// Find a pending invitation the user (with the given verified email) can respond to
+func getPendingInvitation(code, email string) (Invitation, error) {
+	var inv Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := mongodb.InvitationCollection.FindOne(ctx, bson.M{"Code": code}).Decode(&inv)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			logger.Warn("[LEDGER] Invitation not found")
+			return inv, errors.New("invitation not found")
+		}
+		logger.Error("[LEDGER] " + err.Error())
+		return inv, err
+	}
+
+	// Email invitations are only for the invitee, emails are compared case-insensitively
+	if inv.Email != "" && !strings.EqualFold(inv.Email, email) {
+		logger.Warn("[LEDGER] Invitation is for another email")
+		return inv, errors.New("invitation not found")
+	}
+	if inv.Status != invitationPending {
+		logger.Warn("[LEDGER] Invitation is " + inv.Status)
+		return inv, errors.New("invitation is no longer pending")
+	} else if inv.ExpiresAt <= time.Now().Unix() {
+		logger.Warn("[LEDGER] Invitation is expired")
+		return inv, errors.New("invitation is expired")
+	}
+
+	return inv, nil
+}
+
+// Mark a pending invitation as responded
+func respondInvitation(code, status, UUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"Code": code, "Status": invitationPending}
+	update := bson.M{"$set": bson.M{
+		"Status":      status,
+		"RespondedAt": time.Now().Unix(),
+		"RespondedBy": UUID,
+	}}
+
+	result, err := mongodb.InvitationCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	} else if result.MatchedCount == 0 {
+		logger.Warn("[LEDGER] Invitation is no longer pending")
+		return errors.New("invitation is no longer pending")
+	}
+
+	return nil
+}
+
+// Make an invitation accepted by the user pending again, when joining the ledger failed
+func reopenInvitation(code, UUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"Code": code, "Status": invitationAccepted, "RespondedBy": UUID}
+	update := bson.M{
+		"$set":   bson.M{"Status": invitationPending},
+		"$unset": bson.M{"RespondedAt": "", "RespondedBy": ""},
+	}
+
+	if _, err := mongodb.InvitationCollection.UpdateOne(ctx, filter, update); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+
+	logger.Info("[LEDGER] Reopened invitation after a failed accept by user: " + UUID)
+	return nil
+}
+
+// Accept an invitation, the user becomes a member of the ledger.
+// email is the verified email of the user, or empty if it is not verified.
+func AcceptInvitation(code, UUID, email, nickname string) (string, error) {
+	inv, err := getPendingInvitation(code, email)
+	if err != nil {
+		return "", err
+	}
+
+	// Members should not use up an invitation
+	if members, err := GetLedgerMember(inv.ULID); err != nil {
+		return "", err
+	} else if members[UUID] {
+		logger.Warn("[LEDGER] User already exists in the ledger")
+		return "", errors.New("user already exists in the ledger")
+	}
+
+	// Claim the invitation first so it can not be used twice
+	if err = respondInvitation(code, invitationAccepted, UUID); err != nil {
+		return "", err
+	}
+	if err = addMember(inv.ULID, UUID, nickname, inv.Role); err != nil {
+		// The invitation was not used, so it can still be accepted
+		_ = reopenInvitation(code, UUID)
+		return "", err
+	}
+
+	logger.Info("[LEDGER] Invitation accepted, user: " + UUID + " joined ledger: " + inv.ULID)
+	return inv.ULID, nil
+}
+
+// Delete the pending invitations sent by a user or to their email, when the account is deleted
+func deleteUserInvitations(UUID, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"Status": invitationPending,
+		"$or":    bson.A{bson.M{"InviterUUID": UUID}, bson.M{"Email": strings.ToLower(email)}},
+	}
+
+	if _, err := mongodb.InvitationCollection.DeleteMany(ctx, filter); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+
+	logger.Info("[LEDGER] Deleted pending invitations of user: " + UUID)
+	return nil
+}
+
+// Decline an invitation sent to the email of the user
+func DeclineInvitation(code, UUID, email string) error {
+	inv, err := getPendingInvitation(code, email)
+	if err != nil {
+		return err
+	} else if inv.Email == "" {
+		logger.Warn("[LEDGER] Can not decline a shared invitation")
+		return errors.New("only email invitations can be declined")
+	}
+
+	if err = respondInvitation(code, invitationDeclined, UUID); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Invitation declined by user: " + UUID)
+	return nil
+}
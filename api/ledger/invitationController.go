@@ -0,0 +1,106 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+	"net/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createInvitationRequest struct {
+	// Leave empty for an invitation shared as a code / link
+	Email string `json:"Email"`
+	// Role given on acceptance, editor or viewer (default editor)
+	Role           string `json:"Role"`
+	ExpiresInHours *int   `json:"ExpiresInHours"`
+}
+
+func CreateInvitation(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var createInvitationRequest createInvitationRequest
+	if err = c.ShouldBindJSON(&createInvitationRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check pass in fields
+	if email := createInvitationRequest.Email; email != "" {
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			r.Message = "Invalid email address"
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+	}
+	if createInvitationRequest.Role == "" {
+		createInvitationRequest.Role = RoleEditor
+	} else if createInvitationRequest.Role != RoleEditor && createInvitationRequest.Role != RoleViewer {
+		r.Message = "Role should be editor or viewer"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+	if hours := createInvitationRequest.ExpiresInHours; hours != nil && (*hours <= 0 || *hours > int(maxInvitationTTL.Hours())) {
+		r.Message = "ExpiresInHours should be between 1 and " + maxInvitationTTL.String()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Create invitation
+	invitation, err := createInvitation(createInvitationRequest, contextLedger(c), c.MustGet("UUID").(string))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = invitation
+	c.JSON(http.StatusCreated, r)
+}
+
+func GetInvitations(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Get pending invitations
+	invitations, err := getLedgerInvitations(c.Param("ulid"))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = invitations
+	c.JSON(http.StatusOK, r)
+}
+
+func RevokeInvitation(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Revoke invitation
+	if err := revokeInvitation(c.Param("ulid"), c.Param("code")); err != nil {
+		r.Message = err.Error()
+		if r.Message == "invitation not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -16,21 +16,37 @@ import (
 type member struct {
 	UUID     string `json:"UUID" bson:"UUID"`
 	Nickname string `json:"Nickname" bson:"Nickname"`
+	Role     string `json:"Role" bson:"Role"`
+	// Set on members whose account has been deleted or who left the ledger, their UUID is replaced by a random one
+	Deleted bool `json:"Deleted,omitempty" bson:"Deleted,omitempty"`
 }
 
+const deletedMemberNickname = "Deleted user"
+
 type childType struct {
-	CTID int    `json:"CTID" bson:"CTID"`
-	Name string `json:"Name" bson:"Name"`
+	CTID  int    `json:"CTID" bson:"CTID"`
+	Name  string `json:"Name" bson:"Name"`
+	Icon  string `json:"Icon,omitempty" bson:"Icon,omitempty"`
+	Color string `json:"Color,omitempty" bson:"Color,omitempty"`
 }
 
 type parentType struct {
 	PTID       int         `json:"PTID" bson:"PTID"`
 	Name       string      `json:"Name" bson:"Name"`
+	Icon       string      `json:"Icon,omitempty" bson:"Icon,omitempty"`
+	Color      string      `json:"Color,omitempty" bson:"Color,omitempty"`
 	ChildTypes []childType `json:"ChildTypes" bson:"ChildTypes"`
+	// Next CTID to allocate, CTIDs of deleted child types are not reused
+	NextCTID int `json:"-" bson:"NextCTID"`
 }
 
+// Types are listed in display order
 type ledgerType struct {
 	ParentTypes []parentType `json:"ParentTypes" bson:"ParentTypes"`
+	// Next PTID to allocate, PTIDs of deleted parent types are not reused
+	NextPTID int `json:"-" bson:"NextPTID"`
+	// Increased on every save, changes are only saved over the version they were made on
+	Version int `json:"-" bson:"Version"`
 }
 
 type ledger struct {
@@ -39,8 +55,14 @@ type ledger struct {
 	Notification bool       `json:"Notification" bson:"Notification" binding:"required"`
 	Theme        string     `json:"Theme" bson:"Theme" binding:"required"`
 	Currency     string     `json:"Currency" bson:"Currency" binding:"required"`
-	Types        ledgerType `json:"Types" bson:"Types" binding:"required"`
-	Members      []member   `json:"Members" bson:"Members" binding:"required"`
+	Types        ledgerType `json:"Types" bson:"Types"`
+	Members      []member   `json:"Members" bson:"Members"`
+	// Only used on creation: a template to take the Types from, with its names in Locale
+	Template string `json:"Template,omitempty" bson:"-"`
+	Locale   string `json:"Locale,omitempty" bson:"-"`
+	// Archived ledgers are read-only and hidden from the default listing
+	Archived   bool  `json:"Archived" bson:"Archived"`
+	ArchivedAt int64 `json:"ArchivedAt,omitempty" bson:"ArchivedAt,omitempty"`
 }
 
 func GetLedgerMember(ULID string) (map[string]bool, error) {
@@ -113,7 +135,7 @@ func create(l ledger) (string, error) {
 	return l.ULID, nil
 }
 
-func get(UUID string) ([]ledger, error) {
+func get(UUID string, includeArchived bool) ([]ledger, error) {
 	var userLedgers []ledger
 
 	// Get ledger info for a user
@@ -127,6 +149,9 @@ func get(UUID string) ([]ledger, error) {
 			},
 		},
 	}
+	if !includeArchived {
+		filter["Archived"] = bson.M{"$ne": true}
+	}
 
 	cur, err := mongodb.LedgerCollection.Find(ctx, filter)
 	if err != nil {
@@ -138,6 +163,9 @@ func get(UUID string) ([]ledger, error) {
 		logger.Error("[LEDGER] " + err.Error())
 		return userLedgers, err
 	}
+	for i := range userLedgers {
+		fillLegacyRoles(&userLedgers[i])
+	}
 
 	logger.Info("[LEDGER] Get ledger info for user: " + UUID)
 
@@ -185,7 +213,7 @@ func update(ur updateRequest, ULID string) error {
 	return nil
 }
 
-func addMember(amr addMemberRequest, ULID string) error {
+func addMember(ULID, UUID, nickname, role string) error {
 	// check ledger exists first
 	if exist, err := CheckLedgerExists(ULID); err != nil {
 		return err
@@ -202,7 +230,7 @@ func addMember(amr addMemberRequest, ULID string) error {
 		"Members": bson.M{
 			"$not": bson.M{
 				"$elemMatch": bson.M{
-					"UUID": amr.UUID,
+					"UUID": UUID,
 				},
 			},
 		},
@@ -211,8 +239,9 @@ func addMember(amr addMemberRequest, ULID string) error {
 	update := bson.M{
 		"$push": bson.M{
 			"Members": bson.M{
-				"UUID":     amr.UUID,
-				"Nickname": amr.Nickname,
+				"UUID":     UUID,
+				"Nickname": nickname,
+				"Role":     role,
 			},
 		},
 	}
@@ -231,18 +260,38 @@ func addMember(amr addMemberRequest, ULID string) error {
 	return nil
 }
 
-func removeMember(ULID, UUID string) error {
-	// check ledger exists first
-	if exist, err := CheckLedgerExists(ULID); err != nil {
+// Remove a member from the ledger. They stay listed as deleted, under a random UUID also put in
+// their transactions, so what they owe or are owed still counts and can be settled.
+func removeMember(l ledger, UUID string) error {
+	var nickname string
+	for _, m := range l.Members {
+		if m.UUID == UUID && !m.Deleted {
+			nickname = m.Nickname
+			// The last owner has to transfer the ownership or delete the ledger instead
+			if m.role() == RoleOwner && otherOwners(l, UUID) == 0 {
+				logger.Warn("[LEDGER] Last owner can not leave ledger: " + l.ULID)
+				return errors.New("the last owner can not leave the ledger")
+			}
+		}
+	}
+	if !isMember(l, UUID) {
+		logger.Warn("[LEDGER] User not found in the ledger")
+		return errors.New("user not found in the ledger")
+	}
+
+	if err := anonymizeMember(l.ULID, UUID, nickname); err != nil {
 		return err
-	} else if !exist {
-		return errors.New("ledger not found")
 	}
 
+	logger.Info("[LEDGER] Removed member: " + UUID + " from ledger: " + l.ULID)
+	return nil
+}
+
+func updateNickname(unr updateNicknameRequest, ULID, UUID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// delete the user from the ledger if they exist
+	// update the user's nickname
 	filter := bson.M{
 		"ULID": ULID,
 		"Members": bson.M{
@@ -253,10 +302,8 @@ func removeMember(ULID, UUID string) error {
 	}
 
 	update := bson.M{
-		"$pull": bson.M{
-			"Members": bson.M{
-				"UUID": UUID,
-			},
+		"$set": bson.M{
+			"Members.$.Nickname": unr.Nickname,
 		},
 	}
 
@@ -270,47 +317,267 @@ func removeMember(ULID, UUID string) error {
 		return result.Err()
 	}
 
-	logger.Info("[LEDGER] Removed member from ledger: " + ULID)
+	logger.Info("[LEDGER] Updated member nickname in ledger: " + ULID)
 	return nil
 }
 
-func updateNickname(unr updateNicknameRequest, ULID, UUID string) error {
-	// check ledger exists first
-	if exist, err := CheckLedgerExists(ULID); err != nil {
+// Count the owners of the ledger other than the user, deleted accounts excluded
+func otherOwners(l ledger, UUID string) int {
+	owners := 0
+	for _, m := range l.Members {
+		if m.UUID != UUID && m.role() == RoleOwner && !m.Deleted {
+			owners++
+		}
+	}
+	return owners
+}
+
+func isMember(l ledger, UUID string) bool {
+	for _, m := range l.Members {
+		if m.UUID == UUID && !m.Deleted {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the roles of the members, others keep theirs
+func setRoles(l ledger, roles map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The whole Members array is written, so members added before roles existed keep the roles they were given on load
+	for i := range l.Members {
+		if role, ok := roles[l.Members[i].UUID]; ok {
+			l.Members[i].Role = role
+		} else {
+			l.Members[i].Role = l.Members[i].role()
+		}
+	}
+
+	_, err := mongodb.LedgerCollection.UpdateOne(ctx, bson.M{"ULID": l.ULID}, bson.M{"$set": bson.M{"Members": l.Members}})
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func updateRole(l ledger, UUID, role string) error {
+	// The ledger should keep at least one owner
+	if !isMember(l, UUID) {
+		logger.Warn("[LEDGER] User not found in the ledger")
+		return errors.New("user not found in the ledger")
+	} else if role != RoleOwner && otherOwners(l, UUID) == 0 {
+		logger.Warn("[LEDGER] Can not demote the last owner of ledger: " + l.ULID)
+		return errors.New("ledger should have at least one owner")
+	}
+
+	if err := setRoles(l, map[string]string{UUID: role}); err != nil {
 		return err
-	} else if !exist {
-		return errors.New("ledger not found")
+	}
+
+	logger.Info("[LEDGER] Updated role of member: " + UUID + " in ledger: " + l.ULID)
+	return nil
+}
+
+// Make another member the owner, the current owner becomes an editor
+func transferOwnership(l ledger, fromUUID, toUUID string) error {
+	if fromUUID == toUUID {
+		return errors.New("can not transfer the ownership to yourself")
+	} else if !isMember(l, toUUID) {
+		logger.Warn("[LEDGER] User not found in the ledger")
+		return errors.New("user not found in the ledger")
+	}
+
+	if err := setRoles(l, map[string]string{fromUUID: RoleEditor, toUUID: RoleOwner}); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Transferred ownership of ledger: " + l.ULID + " to: " + toUUID)
+	return nil
+}
+
+// Promote a member when the ledger is left without owners, editors first then viewers
+func ensureOwner(l ledger, leavingUUID string) error {
+	if otherOwners(l, leavingUUID) > 0 {
+		return nil
+	}
+
+	successor := ""
+	for _, role := range []string{RoleEditor, RoleViewer} {
+		for _, m := range l.Members {
+			if m.UUID != leavingUUID && !m.Deleted && m.role() == role {
+				successor = m.UUID
+				break
+			}
+		}
+		if successor != "" {
+			break
+		}
+	}
+	if successor == "" {
+		return nil
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// update the user's nickname
-	filter := bson.M{
-		"ULID": ULID,
-		"Members": bson.M{
-			"$elemMatch": bson.M{
-				"UUID": UUID,
-			},
-		},
+	_, err := mongodb.LedgerCollection.UpdateOne(ctx,
+		bson.M{"ULID": l.ULID},
+		bson.M{"$set": bson.M{"Members.$[m].Role": RoleOwner}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"m.UUID": successor}}}),
+	)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"Members.$.Nickname": unr.Nickname,
-		},
+	logger.Info("[LEDGER] Promoted member: " + successor + " to owner of ledger: " + l.ULID)
+	return nil
+}
+
+// Remove a deleted account from every ledger it belongs to.
+// Ledgers without other members are deleted with their transactions,
+// otherwise the member and the transactions it appears in are anonymized
+// so the other members keep a consistent history, and another member
+// becomes owner if the user was the last one. The pending invitations sent by
+// the user or to their email are deleted.
+func RemoveUser(UUID, email string) error {
+	var err error
+	var userLedgers []ledger
+	if userLedgers, err = get(UUID, true); err != nil {
+		return err
 	}
 
-	result := mongodb.LedgerCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate())
-	if result.Err() != nil {
-		if result.Err() == mongo.ErrNoDocuments {
-			logger.Error("[LEDGER] User not found in the ledger")
-			return errors.New("user not found in the ledger")
+	for _, l := range userLedgers {
+		// Count the members who still have an account
+		others := 0
+		for _, m := range l.Members {
+			if m.UUID != UUID && !m.Deleted {
+				others++
+			}
+		}
+
+		if others == 0 {
+			err = deleteLedger(l.ULID)
+		} else if err = ensureOwner(l, UUID); err == nil {
+			err = anonymizeMember(l.ULID, UUID, deletedMemberNickname)
+		}
+		if err != nil {
+			return err
 		}
-		logger.Error("[LEDGER] " + result.Err().Error())
-		return result.Err()
 	}
 
-	logger.Info("[LEDGER] Updated member nickname in ledger: " + ULID)
+	if err = deleteUserTemplates(UUID); err != nil {
+		return err
+	}
+	if err = deleteUserInvitations(UUID, email); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Removed user from all ledgers: " + UUID)
+	return nil
+}
+
+// Archive or restore a ledger
+func setArchived(ULID string, archived bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"Archived": true, "ArchivedAt": time.Now().Unix()}}
+	if !archived {
+		update = bson.M{"$set": bson.M{"Archived": false}, "$unset": bson.M{"ArchivedAt": ""}}
+	}
+
+	result, err := mongodb.LedgerCollection.UpdateOne(ctx, bson.M{"ULID": ULID, "Archived": bson.M{"$ne": archived}}, update)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	} else if result.MatchedCount == 0 {
+		if archived {
+			logger.Warn("[LEDGER] Ledger is already archived: " + ULID)
+			return errors.New("ledger is already archived")
+		}
+		logger.Warn("[LEDGER] Ledger is not archived: " + ULID)
+		return errors.New("ledger is not archived")
+	}
+
+	if archived {
+		logger.Info("[LEDGER] Archived ledger: " + ULID)
+	} else {
+		logger.Info("[LEDGER] Restored ledger: " + ULID)
+	}
+	return nil
+}
+
+// Delete a ledger with all its transactions and invitations
+func deleteLedger(ULID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mongodb.TransactionCollection.DeleteMany(ctx, bson.M{"ULID": ULID}); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+	if _, err := mongodb.InvitationCollection.DeleteMany(ctx, bson.M{"ULID": ULID}); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+	if _, err := mongodb.LedgerCollection.DeleteOne(ctx, bson.M{"ULID": ULID}); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+
+	logger.Info("[LEDGER] Deleted ledger: " + ULID)
+	return nil
+}
+
+// Replace the UUID of a member by a random one in the ledger and its transactions,
+// the member is kept as deleted under the given nickname
+func anonymizeMember(ULID, UUID, nickname string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tombstone := uuid.NewString()
+
+	// Transactions first so a failure leaves the member still linked to them
+	_, err := mongodb.TransactionCollection.UpdateMany(ctx,
+		bson.M{"ULID": ULID, "Payer": UUID},
+		bson.M{"$set": bson.M{"Payer": tombstone}},
+	)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+
+	_, err = mongodb.TransactionCollection.UpdateMany(ctx,
+		bson.M{"ULID": ULID, "Sharers.UUID": UUID},
+		bson.M{"$set": bson.M{"Sharers.$[s].UUID": tombstone}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"s.UUID": UUID}}}),
+	)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+
+	result, err := mongodb.LedgerCollection.UpdateOne(ctx,
+		bson.M{"ULID": ULID, "Members.UUID": UUID},
+		bson.M{"$set": bson.M{
+			"Members.$[m].UUID":     tombstone,
+			"Members.$[m].Nickname": nickname,
+			"Members.$[m].Deleted":  true,
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"m.UUID": UUID}}}),
+	)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	} else if result.MatchedCount == 0 {
+		logger.Warn("[LEDGER] User not found in the ledger")
+		return errors.New("user not found in the ledger")
+	}
+
+	logger.Info("[LEDGER] Anonymized deleted member in ledger: " + ULID)
 	return nil
 }
@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"Fortune_Tracker_API/internal/auth"
 	"Fortune_Tracker_API/internal/response"
 	"Fortune_Tracker_API/pkg/logger"
 	"net/http"
@@ -16,9 +17,17 @@ type updateRequest struct {
 	Currency     *string `json:"Currency" bson:"Currency"`
 }
 
-type addMemberRequest struct {
-	UUID     string `json:"UUID" bson:"UUID" binding:"required"`
-	Nickname string `json:"Nickname" bson:"Nickname" binding:"required"`
+type updateRoleRequest struct {
+	Role string `json:"Role" binding:"required"`
+}
+
+type deleteRequest struct {
+	// Name of the ledger, to confirm the ledger and its transactions should be deleted
+	ConfirmName string `json:"ConfirmName" binding:"required"`
+}
+
+type transferOwnershipRequest struct {
+	UUID string `json:"UUID" binding:"required"`
 }
 
 type updateNicknameRequest struct {
@@ -41,6 +50,50 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	// Types are either submitted or taken from a template
+	if !isValidLocale(ledger.Locale) {
+		r.Message = "Locale should be en or zh-TW"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+	if ledger.Template != "" {
+		if len(ledger.Types.ParentTypes) > 0 {
+			r.Message = "Types and Template can not be given together"
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		if ledger.Types, err = getTemplateTypes(ledger.Template, ledger.Locale, c.MustGet("UUID").(string)); err != nil {
+			r.Message = err.Error()
+			if r.Message == "template not found" {
+				c.JSON(http.StatusBadRequest, r)
+				return
+			}
+			c.JSON(http.StatusInternalServerError, r)
+			return
+		}
+	} else if len(ledger.Types.ParentTypes) == 0 {
+		r.Message = "Types or Template is required"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check the category IDs and start their counters
+	if err = initTypes(&ledger.Types); err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// The creator is the only member, others join by accepting an invitation
+	UUID := c.MustGet("UUID").(string)
+	nickname := ""
+	for _, m := range ledger.Members {
+		if m.UUID == UUID {
+			nickname = m.Nickname
+		}
+	}
+	ledger.Members = []member{{UUID: UUID, Nickname: nickname, Role: RoleOwner}}
+
 	// Create ledger
 	if ULID, err = create(ledger); err != nil {
 		logger.Warn("[LEDGER] " + err.Error())
@@ -65,14 +118,26 @@ func Get(c *gin.Context) {
 	// Get UUID 
 	UUID := c.MustGet("UUID").(string)
 
-	// Get ledger info
-	if userLedgers, err = get(UUID); err != nil {
+	// Get ledger info, archived ledgers only when asked for
+	includeArchived := c.Query("includeArchived") == "true"
+	if userLedgers, err = get(UUID, includeArchived); err != nil {
 		logger.Warn("[LEDGER] " + err.Error())
 		r.Message = err.Error()
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
+	// API tokens restricted to some ledgers only see those
+	if allowed, ok := auth.AllowedLedgers(c); ok {
+		visible := []ledger{}
+		for _, l := range userLedgers {
+			if allowed[l.ULID] {
+				visible = append(visible, l)
+			}
+		}
+		userLedgers = visible
+	}
+
 	// Return response
 	r.Status = true
 	r.Data = userLedgers
@@ -94,6 +159,13 @@ func Update(c *gin.Context) {
 		return
 	}
 
+	// Only owners can rename the ledger or change its currency
+	if (updateRequest.Name != nil || updateRequest.Currency != nil) && c.MustGet("LedgerRole").(string) != RoleOwner {
+		r.Message = "this action requires the owner role"
+		c.JSON(http.StatusForbidden, r)
+		return
+	}
+
 	// Update ledger
 	if err = update(updateRequest, c.Param("ulid")); err != nil {
 		logger.Error("[LEDGER] " + err.Error())
@@ -112,33 +184,58 @@ func Update(c *gin.Context) {
 	c.JSON(http.StatusOK, r)
 }
 
-func AddMember(c *gin.Context) {
+func Archive(c *gin.Context) {
+	setArchivedHandler(c, true)
+}
+
+func Restore(c *gin.Context) {
+	setArchivedHandler(c, false)
+}
+
+func setArchivedHandler(c *gin.Context, archived bool) {
+	// Create response
+	r := response.New()
+
+	// Archive or restore ledger
+	if err := setArchived(c.Param("ulid"), archived); err != nil {
+		r.Message = err.Error()
+		if r.Message == "ledger is already archived" || r.Message == "ledger is not archived" {
+			c.JSON(http.StatusConflict, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func Delete(c *gin.Context) {
 	var err error
 
 	// Create response
 	r := response.New()
 
 	// Parse request body to JSON format
-	var addMemberRequest addMemberRequest
-	if err = c.ShouldBindJSON(&addMemberRequest); err != nil {
+	var deleteRequest deleteRequest
+	if err = c.ShouldBindJSON(&deleteRequest); err != nil {
 		logger.Warn("[LEDGER] " + err.Error())
 		r.Message = err.Error()
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
-	// Add member
-	if err = addMember(addMemberRequest, c.Param("ulid")); err != nil {
-		if err.Error() == "ledger not found" {
-			r.Message = "Ledger not found"
-			c.JSON(http.StatusNotFound, r)
-			return
-		} else if err.Error() == "user already exists in the ledger" {
-			r.Message = err.Error()
-			c.JSON(http.StatusBadRequest, r)
-			return
-		}
-		logger.Error("[LEDGER] " + err.Error())
+	// The name of the ledger confirms the deletion
+	if deleteRequest.ConfirmName != contextLedger(c).Name {
+		r.Message = "ConfirmName does not match the ledger name"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Delete ledger and its transactions
+	if err = deleteLedger(c.Param("ulid")); err != nil {
 		r.Message = err.Error()
 		c.JSON(http.StatusInternalServerError, r)
 		return
@@ -149,22 +246,32 @@ func AddMember(c *gin.Context) {
 	c.JSON(http.StatusOK, r)
 }
 
+// Leave the ledger
 func RemoveMember(c *gin.Context) {
+	removeMemberHandler(c, c.MustGet("UUID").(string))
+}
+
+// Remove another member from the ledger
+func RemoveOtherMember(c *gin.Context) {
+	removeMemberHandler(c, c.Param("uuid"))
+}
+
+func removeMemberHandler(c *gin.Context, UUID string) {
 	var err error
 
 	// Create response
 	r := response.New()
 
 	// Remove member
-	if err = removeMember(c.Param("ulid"), c.MustGet("UUID").(string)); err != nil {
-		if err.Error() == "ledger not found" {
-			r.Message = "Ledger not found"
-			c.JSON(http.StatusNotFound, r)
-			return
-		} else if err.Error() == "user not found in the ledger" {
+	if err = removeMember(contextLedger(c), UUID); err != nil {
+		if err.Error() == "user not found in the ledger" {
 			r.Message = err.Error()
 			c.JSON(http.StatusBadRequest, r)
 			return
+		} else if err.Error() == "the last owner can not leave the ledger" {
+			r.Message = "the last owner can not leave the ledger, transfer the ownership or delete the ledger instead"
+			c.JSON(http.StatusConflict, r)
+			return
 		}
 		logger.Error("[LEDGER] " + err.Error())
 		r.Message = err.Error()
@@ -194,17 +301,90 @@ func UpdateNickname(c *gin.Context) {
 
 	// Update nickname
 	if err = updateNickname(updateNicknameRequest, c.Param("ulid"), c.MustGet("UUID").(string)); err != nil {
-		if err.Error() == "ledger not found" {
-			r.Message = "Ledger not found"
+		if err.Error() == "user not found in the ledger" {
+			r.Message = err.Error()
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		logger.Error("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func UpdateRole(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var updateRoleRequest updateRoleRequest
+	if err = c.ShouldBindJSON(&updateRoleRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check pass in fields
+	if !isValidRole(updateRoleRequest.Role) {
+		r.Message = "Role should be owner, editor or viewer"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Update role
+	if err = updateRole(contextLedger(c), c.Param("uuid"), updateRoleRequest.Role); err != nil {
+		r.Message = err.Error()
+		if r.Message == "user not found in the ledger" {
 			c.JSON(http.StatusNotFound, r)
 			return
-		} else if err.Error() == "user not found in the ledger" {
-			r.Message = err.Error()
+		} else if r.Message == "ledger should have at least one owner" {
 			c.JSON(http.StatusBadRequest, r)
 			return
 		}
 		logger.Error("[LEDGER] " + err.Error())
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func TransferOwnership(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var transferOwnershipRequest transferOwnershipRequest
+	if err = c.ShouldBindJSON(&transferOwnershipRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Transfer ownership
+	if err = transferOwnership(contextLedger(c), c.MustGet("UUID").(string), transferOwnershipRequest.UUID); err != nil {
 		r.Message = err.Error()
+		if r.Message == "user not found in the ledger" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "can not transfer the ownership to yourself" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		logger.Error("[LEDGER] " + err.Error())
 		c.JSON(http.StatusInternalServerError, r)
 		return
 	}
@@ -0,0 +1,63 @@
+package ledger
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRemovedMemberStaysListedAsDeleted(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	l := ledger{ULID: testULID, Members: []member{
+		{UUID: "alice", Nickname: "Alice", Role: RoleOwner},
+		{UUID: "bob", Nickname: "Bob", Role: RoleEditor},
+	}}
+
+	mt.Run("removed", func(mt *mtest.T) {
+		setupMockCollections(mt)
+		mt.AddMockResponses(matched, matched, matched)
+
+		if err := removeMember(l, "bob"); err != nil {
+			mt.Fatal(err)
+		}
+
+		// Bob's transactions and his entry get the same random UUID, his entry is kept
+		events := mt.GetAllStartedEvents()
+		if names := commandNames(mt); len(names) != 3 || names[0] != "update Transaction" || names[1] != "update Transaction" || names[2] != "update Ledger" {
+			mt.Fatalf("commands %v, want the transactions then the member updated", names)
+		}
+		payer := updateStatement(events[0]).Lookup("u", "$set", "Payer").StringValue()
+		sharer := updateStatement(events[1]).Lookup("u", "$set", "Sharers.$[s].UUID").StringValue()
+		set := updateStatement(events[2]).Lookup("u", "$set")
+		if tombstone := set.Document().Lookup("Members.$[m].UUID").StringValue(); tombstone == "bob" || tombstone != payer || tombstone != sharer {
+			mt.Fatalf("member UUID %s, payer %s, sharer %s, want the same random UUID", tombstone, payer, sharer)
+		}
+		if deleted, _ := set.Document().Lookup("Members.$[m].Deleted").BooleanOK(); !deleted {
+			mt.Fatalf("removed member is not marked deleted: %s", set)
+		}
+		if nickname := set.Document().Lookup("Members.$[m].Nickname").StringValue(); nickname != "Bob" {
+			mt.Fatalf("removed member nickname %s, want Bob", nickname)
+		}
+	})
+
+	mt.Run("not a member", func(mt *mtest.T) {
+		setupMockCollections(mt)
+
+		if err := removeMember(l, "carol"); err == nil || err.Error() != "user not found in the ledger" {
+			mt.Fatalf("got %v, want user not found in the ledger", err)
+		}
+		if names := commandNames(mt); len(names) != 0 {
+			mt.Fatalf("commands %v, want none", names)
+		}
+	})
+
+	mt.Run("last owner", func(mt *mtest.T) {
+		setupMockCollections(mt)
+
+		if err := removeMember(l, "alice"); err == nil || err.Error() != "the last owner can not leave the ledger" {
+			mt.Fatalf("got %v, want the last owner can not leave the ledger", err)
+		}
+	})
+}
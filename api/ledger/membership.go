@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mongodb"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func getLedger(ULID string) (ledger, error) {
+	var l ledger
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := mongodb.LedgerCollection.FindOne(ctx, bson.M{"ULID": ULID}).Decode(&l)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			logger.Warn("[LEDGER] Ledger not found")
+			return l, errors.New("ledger not found")
+		}
+		logger.Error("[LEDGER] " + err.Error())
+		return l, err
+	}
+
+	fillLegacyRoles(&l)
+	return l, nil
+}
+
+// Load the ledger of the :ulid param once per request and check the caller is one of its members.
+// Handlers below get the ledger with contextLedger and the caller's role as "LedgerRole".
+func CheckMembership(c *gin.Context) {
+	r := response.New()
+
+	l, err := getLedger(c.Param("ulid"))
+	if err != nil {
+		r.Message = err.Error()
+		if r.Message == "ledger not found" {
+			c.JSON(http.StatusNotFound, r)
+		} else {
+			c.JSON(http.StatusInternalServerError, r)
+		}
+		c.Abort()
+		return
+	}
+
+	UUID := c.MustGet("UUID").(string)
+	for _, m := range l.Members {
+		if m.UUID == UUID {
+			// User is a member -> continue
+			c.Set("Ledger", l)
+			c.Set("LedgerRole", m.role())
+			c.Next()
+			return
+		}
+	}
+
+	logger.Warn("[LEDGER] User: " + UUID + " is not a member of ledger: " + l.ULID)
+	r.Message = "user is not a member of the ledger"
+	c.JSON(http.StatusForbidden, r)
+	c.Abort()
+}
+
+// Ledger loaded by CheckMembership
+func contextLedger(c *gin.Context) ledger {
+	return c.MustGet("Ledger").(ledger)
+}
+
+// UUIDs of the members of the ledger loaded by CheckMembership, deleted accounts excluded
+func ContextMembers(c *gin.Context) map[string]bool {
+	uuids := make(map[string]bool)
+	for _, m := range contextLedger(c).Members {
+		if !m.Deleted {
+			uuids[m.UUID] = true
+		}
+	}
+	return uuids
+}
+
+// UUIDs of every member of the ledger loaded by CheckMembership, also the deleted accounts,
+// who can still be paid back or pay back what they owe
+func ContextSettlementMembers(c *gin.Context) map[string]bool {
+	uuids := make(map[string]bool)
+	for _, m := range contextLedger(c).Members {
+		uuids[m.UUID] = true
+	}
+	return uuids
+}
+
+// Categories of the ledger loaded by CheckMembership, CTIDs by PTID
+func ContextCategories(c *gin.Context) map[int]map[int]bool {
+	categories := make(map[int]map[int]bool)
+	for _, pt := range contextLedger(c).Types.ParentTypes {
+		categories[pt.PTID] = make(map[int]bool)
+		for _, ct := range pt.ChildTypes {
+			categories[pt.PTID][ct.CTID] = true
+		}
+	}
+	return categories
+}
+
+// Currency of the ledger loaded by CheckMembership
+func ContextCurrency(c *gin.Context) string {
+	return contextLedger(c).Currency
+}
@@ -0,0 +1,131 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Member roles, each role can do everything the roles below it can
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+func isValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Role of the member, members added before roles existed get theirs from fillLegacyRoles
+// when the ledger is loaded and can not do more than editors otherwise
+func (m member) role() string {
+	if m.Role == "" {
+		return RoleEditor
+	}
+	return m.Role
+}
+
+// Give roles to the members added before roles existed: unless the ledger already has an owner,
+// the first of them who still has an account created the ledger and is its owner, the others are editors
+func fillLegacyRoles(l *ledger) {
+	hasOwner := false
+	for _, m := range l.Members {
+		if m.Role == RoleOwner && !m.Deleted {
+			hasOwner = true
+		}
+	}
+
+	for i := range l.Members {
+		if l.Members[i].Role != "" {
+			continue
+		}
+		if !hasOwner && !l.Members[i].Deleted {
+			l.Members[i].Role = RoleOwner
+			hasOwner = true
+		} else {
+			l.Members[i].Role = RoleEditor
+		}
+	}
+}
+
+// Minimum role needed for each route of the /ledger/:ulid group, keyed by method and route path.
+// Routes not listed are only available to owners.
+var routePermissions = map[string]string{
+	"PATCH /ledger/:ulid/":                         RoleEditor, // renaming or changing currency is checked in Update
+	"POST /ledger/:ulid/invitation":                RoleOwner,
+	"GET /ledger/:ulid/invitation":                 RoleOwner,
+	"DELETE /ledger/:ulid/invitation/:code":        RoleOwner,
+	"PATCH /ledger/:ulid/member":                   RoleViewer,
+	"DELETE /ledger/:ulid/member":                  RoleViewer,
+	"DELETE /ledger/:ulid/member/:uuid":            RoleOwner,
+	"POST /ledger/:ulid/owner":                     RoleOwner,
+	"PUT /ledger/:ulid/member/:uuid/role":          RoleOwner,
+	"GET /ledger/:ulid/types":                      RoleViewer,
+	"POST /ledger/:ulid/types":                     RoleEditor,
+	"PUT /ledger/:ulid/types/order":                RoleEditor,
+	"PATCH /ledger/:ulid/types/:ptid":              RoleEditor,
+	"DELETE /ledger/:ulid/types/:ptid":             RoleEditor,
+	"POST /ledger/:ulid/types/:ptid/child":         RoleEditor,
+	"PUT /ledger/:ulid/types/:ptid/child/order":    RoleEditor,
+	"PATCH /ledger/:ulid/types/:ptid/child/:ctid":  RoleEditor,
+	"DELETE /ledger/:ulid/types/:ptid/child/:ctid": RoleEditor,
+	"POST /ledger/:ulid/template":                  RoleViewer,
+	"GET /ledger/:ulid/balances":                   RoleViewer,
+	"GET /ledger/:ulid/settle-up":                  RoleViewer,
+	"POST /ledger/:ulid/settlement":                RoleEditor,
+	"POST /ledger/:ulid/transaction":               RoleEditor,
+	"DELETE /ledger/:ulid/transaction/:utid":       RoleEditor,
+	"GET /ledger/:ulid/transaction/:utid":          RoleViewer,
+	"GET /ledger/:ulid/transaction/consistency":    RoleViewer,
+	"GET /ledger/:ulid/transaction/time":           RoleViewer,
+	"PUT /ledger/:ulid/transaction/:utid":          RoleEditor,
+}
+
+// Changes still allowed on archived ledgers
+var archivedRoutes = map[string]bool{
+	"DELETE /ledger/:ulid/":       true,
+	"POST /ledger/:ulid/restore":  true,
+	"DELETE /ledger/:ulid/member": true,
+}
+
+// Check the caller has the role the route needs, runs after CheckMembership
+func CheckPermission(c *gin.Context) {
+	role := c.MustGet("LedgerRole").(string)
+	route := c.Request.Method + " " + c.FullPath()
+
+	required, ok := routePermissions[route]
+	if !ok {
+		required = RoleOwner
+	}
+	if roleRank[role] < roleRank[required] {
+		logger.Warn("[LEDGER] " + role + " can not access " + route)
+		r := response.New()
+		r.Message = "this action requires the " + required + " role"
+		c.JSON(http.StatusForbidden, r)
+		c.Abort()
+		return
+	}
+
+	// Archived ledgers are read-only
+	if contextLedger(c).Archived && c.Request.Method != http.MethodGet && !archivedRoutes[route] {
+		logger.Warn("[LEDGER] Ledger is archived: " + c.Param("ulid"))
+		r := response.New()
+		r.Message = "ledger is archived"
+		c.JSON(http.StatusConflict, r)
+		c.Abort()
+		return
+	}
+
+	// Role is valid -> continue
+	c.Next()
+}
@@ -0,0 +1,51 @@
+package ledger
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFillLegacyRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []member
+		want    []string
+	}{
+		{"creator owns a ledger from before roles",
+			[]member{{UUID: "a"}, {UUID: "b"}, {UUID: "c"}},
+			[]string{RoleOwner, RoleEditor, RoleEditor}},
+		{"first member with an account when the creator was deleted",
+			[]member{{UUID: "a", Deleted: true}, {UUID: "b"}, {UUID: "c"}},
+			[]string{RoleEditor, RoleOwner, RoleEditor}},
+		{"members invited with a role keep it",
+			[]member{{UUID: "a"}, {UUID: "b", Role: RoleViewer}, {UUID: "c"}},
+			[]string{RoleOwner, RoleViewer, RoleEditor}},
+		{"no other owner when the ledger has one",
+			[]member{{UUID: "a"}, {UUID: "b", Role: RoleOwner}},
+			[]string{RoleEditor, RoleOwner}},
+		{"deleted owner does not count",
+			[]member{{UUID: "a", Role: RoleOwner, Deleted: true}, {UUID: "b"}},
+			[]string{RoleOwner, RoleOwner}},
+		{"ledger with roles is unchanged",
+			[]member{{UUID: "a", Role: RoleEditor}, {UUID: "b", Role: RoleOwner}},
+			[]string{RoleEditor, RoleOwner}},
+	}
+	for _, tt := range tests {
+		l := ledger{Members: tt.members}
+		fillLegacyRoles(&l)
+
+		var got []string
+		for _, m := range l.Members {
+			got = append(got, m.Role)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: roles %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoleWithoutFill(t *testing.T) {
+	if role := (member{UUID: "a"}).role(); role != RoleEditor {
+		t.Fatalf("role of a member without one = %s, want %s", role, RoleEditor)
+	}
+}
@@ -0,0 +1,143 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mongodb"
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A category tree saved by a user to create ledgers from
+type template struct {
+	TPID      string     `json:"TPID" bson:"TPID"`
+	UUID      string     `json:"UUID" bson:"UUID"`
+	Name      string     `json:"Name" bson:"Name"`
+	Types     ledgerType `json:"Types" bson:"Types"`
+	CreatedAt int64      `json:"CreatedAt" bson:"CreatedAt"`
+}
+
+// Built-in and saved templates as listed to users, Template is what ledger creation takes
+type templateInfo struct {
+	Template string     `json:"Template"`
+	Name     string     `json:"Name"`
+	Builtin  bool       `json:"Builtin"`
+	Types    ledgerType `json:"Types"`
+}
+
+// Get the category tree of a built-in template (by name) or of a template saved by the user (by TPID)
+func getTemplateTypes(name, locale, UUID string) (ledgerType, error) {
+	if bt, ok := builtinTemplates[name]; ok {
+		return bt.types(locale), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var t template
+	err := mongodb.TemplateCollection.FindOne(ctx, bson.M{"TPID": name, "UUID": UUID}).Decode(&t)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			logger.Warn("[LEDGER] Template not found: " + name)
+			return ledgerType{}, errors.New("template not found")
+		}
+		logger.Error("[LEDGER] " + err.Error())
+		return ledgerType{}, err
+	}
+
+	return t.Types, nil
+}
+
+// List the built-in templates in the locale and the templates saved by the user
+func getTemplates(locale, UUID string) ([]templateInfo, error) {
+	templates := []templateInfo{}
+
+	names := make([]string, 0, len(builtinTemplates))
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bt := builtinTemplates[name]
+		templates = append(templates, templateInfo{Template: name, Name: bt.Name.in(locale), Builtin: true, Types: bt.types(locale)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var saved []template
+	cur, err := mongodb.TemplateCollection.Find(ctx, bson.M{"UUID": UUID}, options.Find().SetSort(bson.M{"CreatedAt": 1}))
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return templates, err
+	}
+	if err = cur.All(ctx, &saved); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return templates, err
+	}
+	for _, t := range saved {
+		templates = append(templates, templateInfo{Template: t.TPID, Name: t.Name, Types: t.Types})
+	}
+
+	logger.Info("[LEDGER] Get templates for user: " + UUID)
+
+	return templates, nil
+}
+
+// Save the category tree of a ledger as a template of the user
+func saveTemplate(l ledger, UUID, name string) (string, error) {
+	t := template{
+		TPID:      uuid.NewString(),
+		UUID:      UUID,
+		Name:      name,
+		Types:     l.Types,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mongodb.TemplateCollection.InsertOne(ctx, t); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return "", err
+	}
+
+	logger.Info("[LEDGER] Saved types of ledger: " + l.ULID + " as template: " + t.TPID)
+
+	return t.TPID, nil
+}
+
+func deleteTemplate(TPID, UUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongodb.TemplateCollection.DeleteOne(ctx, bson.M{"TPID": TPID, "UUID": UUID})
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	} else if result.DeletedCount == 0 {
+		logger.Warn("[LEDGER] Template not found: " + TPID)
+		return errors.New("template not found")
+	}
+
+	logger.Info("[LEDGER] Deleted template: " + TPID)
+	return nil
+}
+
+// Delete the templates saved by a user
+func deleteUserTemplates(UUID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mongodb.TemplateCollection.DeleteMany(ctx, bson.M{"UUID": UUID}); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+	return nil
+}
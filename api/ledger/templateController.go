@@ -0,0 +1,94 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type saveTemplateRequest struct {
+	Name string `json:"Name" binding:"required"`
+}
+
+// Check the locale is supported, an empty locale is the default one
+func isValidLocale(locale string) bool {
+	return locale == "" || supportedLocales[locale]
+}
+
+// List the built-in templates (names in ?locale=) and the templates saved by the user
+func GetTemplates(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	locale := c.Query("locale")
+	if !isValidLocale(locale) {
+		r.Message = "locale should be en or zh-TW"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Get templates
+	templates, err := getTemplates(locale, c.MustGet("UUID").(string))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = templates
+	c.JSON(http.StatusOK, r)
+}
+
+// Save the category tree of the ledger as a template of the user
+func SaveTemplate(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var saveTemplateRequest saveTemplateRequest
+	if err = c.ShouldBindJSON(&saveTemplateRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Save template
+	TPID, err := saveTemplate(contextLedger(c), c.MustGet("UUID").(string), saveTemplateRequest.Name)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = response.TPIDResponse{TPID: TPID}
+	c.JSON(http.StatusCreated, r)
+}
+
+func DeleteTemplate(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Delete template
+	if err := deleteTemplate(c.Param("tpid"), c.MustGet("UUID").(string)); err != nil {
+		r.Message = err.Error()
+		if r.Message == "template not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,469 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mongodb"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Category IDs are stored as uint8 in transactions
+const maxTypeID = 255
+
+// Allocate the IDs counters of a category tree submitted as a whole (e.g. on ledger creation)
+func initTypes(lt *ledgerType) error {
+	lt.NextPTID = 1
+	lt.Version = 0
+	PTIDs := make(map[int]bool)
+	for i := range lt.ParentTypes {
+		pt := &lt.ParentTypes[i]
+		if pt.PTID < 1 || pt.PTID > maxTypeID || PTIDs[pt.PTID] {
+			return errors.New("PTID should be unique and between 1 and " + strconv.Itoa(maxTypeID))
+		}
+		PTIDs[pt.PTID] = true
+		if pt.PTID >= lt.NextPTID {
+			lt.NextPTID = pt.PTID + 1
+		}
+
+		pt.NextCTID = 1
+		CTIDs := make(map[int]bool)
+		for _, ct := range pt.ChildTypes {
+			if ct.CTID < 1 || ct.CTID > maxTypeID || CTIDs[ct.CTID] {
+				return errors.New("CTID should be unique in its parent type and between 1 and " + strconv.Itoa(maxTypeID))
+			}
+			CTIDs[ct.CTID] = true
+			if ct.CTID >= pt.NextCTID {
+				pt.NextCTID = ct.CTID + 1
+			}
+		}
+	}
+	return nil
+}
+
+// Next PTID to allocate, IDs are never reused even after the type is deleted
+func (lt *ledgerType) allocPTID() (int, error) {
+	// Ledgers created before the counters existed start after the highest ID in use
+	for _, pt := range lt.ParentTypes {
+		if pt.PTID >= lt.NextPTID {
+			lt.NextPTID = pt.PTID + 1
+		}
+	}
+	if lt.NextPTID < 1 {
+		lt.NextPTID = 1
+	}
+	if lt.NextPTID > maxTypeID {
+		return 0, errors.New("no more parent type IDs available")
+	}
+	PTID := lt.NextPTID
+	lt.NextPTID++
+	return PTID, nil
+}
+
+// Next CTID to allocate in the parent type, IDs are never reused even after the type is deleted
+func (pt *parentType) allocCTID() (int, error) {
+	for _, ct := range pt.ChildTypes {
+		if ct.CTID >= pt.NextCTID {
+			pt.NextCTID = ct.CTID + 1
+		}
+	}
+	if pt.NextCTID < 1 {
+		pt.NextCTID = 1
+	}
+	if pt.NextCTID > maxTypeID {
+		return 0, errors.New("no more child type IDs available")
+	}
+	CTID := pt.NextCTID
+	pt.NextCTID++
+	return CTID, nil
+}
+
+// Copy of the category tree which does not share its slices
+func (lt ledgerType) clone() ledgerType {
+	lt.ParentTypes = append([]parentType(nil), lt.ParentTypes...)
+	for i := range lt.ParentTypes {
+		lt.ParentTypes[i].ChildTypes = append([]childType(nil), lt.ParentTypes[i].ChildTypes...)
+	}
+	return lt
+}
+
+func (lt *ledgerType) findParent(PTID int) (int, error) {
+	for i, pt := range lt.ParentTypes {
+		if pt.PTID == PTID {
+			return i, nil
+		}
+	}
+	return -1, errors.New("parent type not found")
+}
+
+func (pt *parentType) findChild(CTID int) (int, error) {
+	for i, ct := range pt.ChildTypes {
+		if ct.CTID == CTID {
+			return i, nil
+		}
+	}
+	return -1, errors.New("child type not found")
+}
+
+// Save the category tree, unless it was changed since it was read (lt.Version is the version read)
+func saveTypes(ULID string, lt ledgerType) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ULID": ULID, "Types.Version": lt.Version}
+	if lt.Version == 0 {
+		// Ledgers created before the version existed have none
+		filter["Types.Version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	lt.Version++
+
+	result, err := mongodb.LedgerCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"Types": lt}})
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	} else if result.MatchedCount == 0 {
+		logger.Warn("[LEDGER] Types of ledger: " + ULID + " were changed concurrently")
+		return errors.New("types were changed concurrently")
+	}
+	return nil
+}
+
+// Count the transactions of the ledger in a category, CTID 0 counts the whole parent type
+func countTypeTransactions(ULID string, PTID, CTID int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ULID": ULID, "Type.ParentType": PTID}
+	if CTID != 0 {
+		filter["Type.ChildType"] = CTID
+	}
+
+	count, err := mongodb.TransactionCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return 0, err
+	}
+	return count, nil
+}
+
+// Move the transactions of a category to another one
+func moveTypeTransactions(ULID string, fromPTID, fromCTID, toPTID, toCTID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"ULID": ULID, "Type.ParentType": fromPTID, "Type.ChildType": fromCTID}
+	update := bson.M{"$set": bson.M{"Type.ParentType": toPTID, "Type.ChildType": toCTID}}
+
+	if _, err := mongodb.TransactionCollection.UpdateMany(ctx, filter, update); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// Move the transactions of the child types of a parent type to another one in a single update, child types
+// are renumbered by CTIDs (old and new CTID pairs). Transactions of child types which no longer exist keep
+// their IDs, so they are listed as inconsistent.
+func moveParentTypeTransactions(ULID string, fromPTID, toPTID int, CTIDs [][2]int) error {
+	if len(CTIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oldCTIDs := bson.A{}
+	branches := bson.A{}
+	for _, IDs := range CTIDs {
+		oldCTIDs = append(oldCTIDs, IDs[0])
+		branches = append(branches, bson.M{"case": bson.M{"$eq": bson.A{"$Type.ChildType", IDs[0]}}, "then": IDs[1]})
+	}
+	childType := bson.M{"$switch": bson.M{"branches": branches, "default": "$Type.ChildType"}}
+
+	filter := bson.M{"ULID": ULID, "Type.ParentType": fromPTID, "Type.ChildType": bson.M{"$in": oldCTIDs}}
+	update := bson.A{bson.M{"$set": bson.M{"Type.ParentType": toPTID, "Type.ChildType": childType}}}
+
+	if _, err := mongodb.TransactionCollection.UpdateMany(ctx, filter, update); err != nil {
+		logger.Error("[LEDGER] " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// Save the types without a category (CTID 0 for a whole parent type), only if no transaction uses it.
+// The transactions are counted once the category is saved as deleted, so creating transactions in it
+// is rejected from then on; when some were created before, the deletion is undone.
+func saveTypesIfUnused(ULID string, original, lt ledgerType, PTID, CTID int) error {
+	if err := saveTypes(ULID, lt); err != nil {
+		return err
+	}
+
+	count, err := countTypeTransactions(ULID, PTID, CTID)
+	if err == nil && count == 0 {
+		return nil
+	}
+
+	// Restore the version read, over the version just saved
+	original.Version = lt.Version + 1
+	if restoreErr := saveTypes(ULID, original); restoreErr != nil {
+		logger.Error("[LEDGER] Could not restore type: " + strconv.Itoa(PTID) + "/" + strconv.Itoa(CTID) + " in ledger: " + ULID)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Warn("[LEDGER] Type: " + strconv.Itoa(PTID) + "/" + strconv.Itoa(CTID) + " is still used by transactions")
+	return errors.New("type is used by transactions")
+}
+
+func createParentType(l ledger, ctr createTypeRequest) (int, error) {
+	var err error
+	pt := parentType{Name: ctr.Name, Icon: ctr.Icon, Color: ctr.Color, ChildTypes: []childType{}, NextCTID: 1}
+	if pt.PTID, err = l.Types.allocPTID(); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		return 0, err
+	}
+	l.Types.ParentTypes = append(l.Types.ParentTypes, pt)
+
+	if err = saveTypes(l.ULID, l.Types); err != nil {
+		return 0, err
+	}
+
+	logger.Info("[LEDGER] Created parent type: " + strconv.Itoa(pt.PTID) + " in ledger: " + l.ULID)
+	return pt.PTID, nil
+}
+
+func createChildType(l ledger, PTID int, ctr createTypeRequest) (int, error) {
+	i, err := l.Types.findParent(PTID)
+	if err != nil {
+		return 0, err
+	}
+	pt := &l.Types.ParentTypes[i]
+
+	ct := childType{Name: ctr.Name, Icon: ctr.Icon, Color: ctr.Color}
+	if ct.CTID, err = pt.allocCTID(); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		return 0, err
+	}
+	pt.ChildTypes = append(pt.ChildTypes, ct)
+
+	if err = saveTypes(l.ULID, l.Types); err != nil {
+		return 0, err
+	}
+
+	logger.Info("[LEDGER] Created child type: " + strconv.Itoa(PTID) + "/" + strconv.Itoa(ct.CTID) + " in ledger: " + l.ULID)
+	return ct.CTID, nil
+}
+
+func updateParentType(l ledger, PTID int, utr updateTypeRequest) error {
+	i, err := l.Types.findParent(PTID)
+	if err != nil {
+		return err
+	}
+	pt := &l.Types.ParentTypes[i]
+
+	// update fields if they are not empty
+	if utr.Name != nil {
+		pt.Name = *utr.Name
+	}
+	if utr.Icon != nil {
+		pt.Icon = *utr.Icon
+	}
+	if utr.Color != nil {
+		pt.Color = *utr.Color
+	}
+
+	if err = saveTypes(l.ULID, l.Types); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Updated parent type: " + strconv.Itoa(PTID) + " in ledger: " + l.ULID)
+	return nil
+}
+
+func updateChildType(l ledger, PTID, CTID int, utr updateTypeRequest) error {
+	i, err := l.Types.findParent(PTID)
+	if err != nil {
+		return err
+	}
+	j, err := l.Types.ParentTypes[i].findChild(CTID)
+	if err != nil {
+		return err
+	}
+	ct := &l.Types.ParentTypes[i].ChildTypes[j]
+
+	// update fields if they are not empty
+	if utr.Name != nil {
+		ct.Name = *utr.Name
+	}
+	if utr.Icon != nil {
+		ct.Icon = *utr.Icon
+	}
+	if utr.Color != nil {
+		ct.Color = *utr.Color
+	}
+
+	if err = saveTypes(l.ULID, l.Types); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Updated child type: " + strconv.Itoa(PTID) + "/" + strconv.Itoa(CTID) + " in ledger: " + l.ULID)
+	return nil
+}
+
+// Delete a parent type. Its child types and their transactions are moved to the parent type
+// mergeInto, when 0 the parent type can only be deleted if no transaction uses it.
+// The types are saved before the transactions are moved, so a failed save leaves the transactions untouched.
+func deleteParentType(l ledger, PTID, mergeInto int) error {
+	i, err := l.Types.findParent(PTID)
+	if err != nil {
+		return err
+	}
+	original := l.Types.clone()
+	source := original.ParentTypes[i]
+	var moved [][2]int
+
+	if mergeInto != 0 {
+		if mergeInto == PTID {
+			return errors.New("can not merge a type into itself")
+		}
+		j, err := l.Types.findParent(mergeInto)
+		if err != nil {
+			return errors.New("merge target not found")
+		}
+		target := &l.Types.ParentTypes[j]
+
+		// Child types are moved to the target with new CTIDs, all of them are allocated before anything is saved
+		for _, ct := range source.ChildTypes {
+			oldCTID := ct.CTID
+			if ct.CTID, err = target.allocCTID(); err != nil {
+				logger.Warn("[LEDGER] " + err.Error())
+				return err
+			}
+			target.ChildTypes = append(target.ChildTypes, ct)
+			moved = append(moved, [2]int{oldCTID, ct.CTID})
+		}
+	}
+
+	l.Types.ParentTypes = append(l.Types.ParentTypes[:i], l.Types.ParentTypes[i+1:]...)
+	if mergeInto == 0 {
+		if err = saveTypesIfUnused(l.ULID, original, l.Types, PTID, 0); err != nil {
+			return err
+		}
+	} else {
+		if err = saveTypes(l.ULID, l.Types); err != nil {
+			return err
+		}
+		if err = moveParentTypeTransactions(l.ULID, PTID, mergeInto, moved); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("[LEDGER] Deleted parent type: " + strconv.Itoa(PTID) + " in ledger: " + l.ULID)
+	return nil
+}
+
+// Delete a child type. Its transactions are moved to the child type mergeIntoCTID of the parent type
+// mergeIntoPTID, when mergeIntoCTID is 0 the child type can only be deleted if no transaction uses it.
+func deleteChildType(l ledger, PTID, CTID, mergeIntoPTID, mergeIntoCTID int) error {
+	i, err := l.Types.findParent(PTID)
+	if err != nil {
+		return err
+	}
+	j, err := l.Types.ParentTypes[i].findChild(CTID)
+	if err != nil {
+		return err
+	}
+	original := l.Types.clone()
+
+	if mergeIntoCTID != 0 {
+		if mergeIntoPTID == PTID && mergeIntoCTID == CTID {
+			return errors.New("can not merge a type into itself")
+		}
+		k, err := l.Types.findParent(mergeIntoPTID)
+		if err != nil {
+			return errors.New("merge target not found")
+		}
+		if _, err = l.Types.ParentTypes[k].findChild(mergeIntoCTID); err != nil {
+			return errors.New("merge target not found")
+		}
+	}
+
+	pt := &l.Types.ParentTypes[i]
+	pt.ChildTypes = append(pt.ChildTypes[:j], pt.ChildTypes[j+1:]...)
+	if mergeIntoCTID == 0 {
+		if err = saveTypesIfUnused(l.ULID, original, l.Types, PTID, CTID); err != nil {
+			return err
+		}
+	} else {
+		if err = saveTypes(l.ULID, l.Types); err != nil {
+			return err
+		}
+		// Transactions are only moved once the child type is deleted
+		if err = moveTypeTransactions(l.ULID, PTID, CTID, mergeIntoPTID, mergeIntoCTID); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("[LEDGER] Deleted child type: " + strconv.Itoa(PTID) + "/" + strconv.Itoa(CTID) + " in ledger: " + l.ULID)
+	return nil
+}
+
+// Reorder the parent types, IDs should list every parent type exactly once
+func reorderParentTypes(l ledger, IDs []int) error {
+	if len(IDs) != len(l.Types.ParentTypes) {
+		return errors.New("order should list every type exactly once")
+	}
+
+	ordered := make([]parentType, 0, len(IDs))
+	seen := make(map[int]bool)
+	for _, PTID := range IDs {
+		i, err := l.Types.findParent(PTID)
+		if err != nil || seen[PTID] {
+			return errors.New("order should list every type exactly once")
+		}
+		seen[PTID] = true
+		ordered = append(ordered, l.Types.ParentTypes[i])
+	}
+	l.Types.ParentTypes = ordered
+
+	if err := saveTypes(l.ULID, l.Types); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Reordered parent types in ledger: " + l.ULID)
+	return nil
+}
+
+// Reorder the child types of a parent type, IDs should list every child type exactly once
+func reorderChildTypes(l ledger, PTID int, IDs []int) error {
+	i, err := l.Types.findParent(PTID)
+	if err != nil {
+		return err
+	}
+	pt := &l.Types.ParentTypes[i]
+	if len(IDs) != len(pt.ChildTypes) {
+		return errors.New("order should list every type exactly once")
+	}
+
+	ordered := make([]childType, 0, len(IDs))
+	seen := make(map[int]bool)
+	for _, CTID := range IDs {
+		j, err := pt.findChild(CTID)
+		if err != nil || seen[CTID] {
+			return errors.New("order should list every type exactly once")
+		}
+		seen[CTID] = true
+		ordered = append(ordered, pt.ChildTypes[j])
+	}
+	pt.ChildTypes = ordered
+
+	if err = saveTypes(l.ULID, l.Types); err != nil {
+		return err
+	}
+
+	logger.Info("[LEDGER] Reordered child types of: " + strconv.Itoa(PTID) + " in ledger: " + l.ULID)
+	return nil
+}
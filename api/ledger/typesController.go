@@ -0,0 +1,312 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createTypeRequest struct {
+	Name  string `json:"Name" binding:"required"`
+	Icon  string `json:"Icon"`
+	Color string `json:"Color"`
+}
+
+type updateTypeRequest struct {
+	Name  *string `json:"Name"`
+	Icon  *string `json:"Icon"`
+	Color *string `json:"Color"`
+}
+
+type reorderTypesRequest struct {
+	// PTIDs or CTIDs in the new order
+	Order []int `json:"Order" binding:"required"`
+}
+
+// Parse an ID param or query of a category, returns false (and responds) if it is invalid
+func parseTypeID(c *gin.Context, r *response.Response, name, value string) (int, bool) {
+	ID, err := strconv.Atoi(value)
+	if err != nil || ID < 1 || ID > maxTypeID {
+		r.Message = name + " should be between 1 and " + strconv.Itoa(maxTypeID)
+		c.JSON(http.StatusBadRequest, r)
+		return 0, false
+	}
+	return ID, true
+}
+
+// Respond with the status matching an error of the types functions
+func typeErrorResponse(c *gin.Context, r *response.Response, err error) {
+	r.Message = err.Error()
+	switch r.Message {
+	case "parent type not found", "child type not found":
+		c.JSON(http.StatusNotFound, r)
+	case "type is used by transactions", "types were changed concurrently":
+		c.JSON(http.StatusConflict, r)
+	case "merge target not found", "can not merge a type into itself", "order should list every type exactly once",
+		"no more parent type IDs available", "no more child type IDs available":
+		c.JSON(http.StatusBadRequest, r)
+	default:
+		c.JSON(http.StatusInternalServerError, r)
+	}
+}
+
+func GetTypes(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Return response
+	r.Status = true
+	r.Data = contextLedger(c).Types
+	c.JSON(http.StatusOK, r)
+}
+
+func CreateParentType(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var createTypeRequest createTypeRequest
+	if err = c.ShouldBindJSON(&createTypeRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Create parent type
+	PTID, err := createParentType(contextLedger(c), createTypeRequest)
+	if err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = response.TypeIDResponse{PTID: PTID}
+	c.JSON(http.StatusCreated, r)
+}
+
+func UpdateParentType(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	PTID, ok := parseTypeID(c, r, "PTID", c.Param("ptid"))
+	if !ok {
+		return
+	}
+
+	// Parse request body to JSON format
+	var updateTypeRequest updateTypeRequest
+	if err = c.ShouldBindJSON(&updateTypeRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Update parent type
+	if err = updateParentType(contextLedger(c), PTID, updateTypeRequest); err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+// Delete a parent type, transactions using it are moved to the parent type given as ?mergeInto=
+func DeleteParentType(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	PTID, ok := parseTypeID(c, r, "PTID", c.Param("ptid"))
+	if !ok {
+		return
+	}
+	mergeInto := 0
+	if c.Query("mergeInto") != "" {
+		if mergeInto, ok = parseTypeID(c, r, "mergeInto", c.Query("mergeInto")); !ok {
+			return
+		}
+	}
+
+	// Delete parent type
+	if err := deleteParentType(contextLedger(c), PTID, mergeInto); err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func ReorderParentTypes(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var reorderTypesRequest reorderTypesRequest
+	if err = c.ShouldBindJSON(&reorderTypesRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Reorder parent types
+	if err = reorderParentTypes(contextLedger(c), reorderTypesRequest.Order); err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func CreateChildType(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	PTID, ok := parseTypeID(c, r, "PTID", c.Param("ptid"))
+	if !ok {
+		return
+	}
+
+	// Parse request body to JSON format
+	var createTypeRequest createTypeRequest
+	if err = c.ShouldBindJSON(&createTypeRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Create child type
+	CTID, err := createChildType(contextLedger(c), PTID, createTypeRequest)
+	if err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = response.TypeIDResponse{PTID: PTID, CTID: CTID}
+	c.JSON(http.StatusCreated, r)
+}
+
+func UpdateChildType(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	PTID, ok := parseTypeID(c, r, "PTID", c.Param("ptid"))
+	if !ok {
+		return
+	}
+	CTID, ok := parseTypeID(c, r, "CTID", c.Param("ctid"))
+	if !ok {
+		return
+	}
+
+	// Parse request body to JSON format
+	var updateTypeRequest updateTypeRequest
+	if err = c.ShouldBindJSON(&updateTypeRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Update child type
+	if err = updateChildType(contextLedger(c), PTID, CTID, updateTypeRequest); err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+// Delete a child type, transactions using it are moved to the child type given as ?mergeInto=
+// (of the parent type given as ?mergeIntoParent=, by default the same parent type)
+func DeleteChildType(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	PTID, ok := parseTypeID(c, r, "PTID", c.Param("ptid"))
+	if !ok {
+		return
+	}
+	CTID, ok := parseTypeID(c, r, "CTID", c.Param("ctid"))
+	if !ok {
+		return
+	}
+	mergeIntoPTID, mergeIntoCTID := PTID, 0
+	if c.Query("mergeIntoParent") != "" {
+		if mergeIntoPTID, ok = parseTypeID(c, r, "mergeIntoParent", c.Query("mergeIntoParent")); !ok {
+			return
+		}
+	}
+	if c.Query("mergeInto") != "" {
+		if mergeIntoCTID, ok = parseTypeID(c, r, "mergeInto", c.Query("mergeInto")); !ok {
+			return
+		}
+	}
+
+	// Delete child type
+	if err := deleteChildType(contextLedger(c), PTID, CTID, mergeIntoPTID, mergeIntoCTID); err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func ReorderChildTypes(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	PTID, ok := parseTypeID(c, r, "PTID", c.Param("ptid"))
+	if !ok {
+		return
+	}
+
+	// Parse request body to JSON format
+	var reorderTypesRequest reorderTypesRequest
+	if err = c.ShouldBindJSON(&reorderTypesRequest); err != nil {
+		logger.Warn("[LEDGER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Reorder child types
+	if err = reorderChildTypes(contextLedger(c), PTID, reorderTypesRequest.Order); err != nil {
+		typeErrorResponse(c, r, err)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,191 @@
+package ledger
+
+import (
+	"Fortune_Tracker_API/pkg/mongodb"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testULID = "0a0a0a0a-0000-4000-8000-00000000000a"
+
+// Food (1) with Lunch (1) and Dinner (2), Transport (2) with Bus (1)
+func typesTestLedger() ledger {
+	return ledger{ULID: testULID, Types: ledgerType{
+		ParentTypes: []parentType{
+			{PTID: 1, Name: "Food", NextCTID: 3, ChildTypes: []childType{{CTID: 1, Name: "Lunch"}, {CTID: 2, Name: "Dinner"}}},
+			{PTID: 2, Name: "Transport", NextCTID: 2, ChildTypes: []childType{{CTID: 1, Name: "Bus"}}},
+		},
+		NextPTID: 3,
+		Version:  4,
+	}}
+}
+
+var (
+	matched    = mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	notMatched = mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0})
+)
+
+// Response to CountDocuments
+func countResponse(n int) bson.D {
+	if n == 0 {
+		return mtest.CreateCursorResponse(0, "Fortune_Tracker.Transaction", mtest.FirstBatch)
+	}
+	return mtest.CreateCursorResponse(0, "Fortune_Tracker.Transaction", mtest.FirstBatch, bson.D{{Key: "n", Value: n}})
+}
+
+func setupMockCollections(mt *mtest.T) {
+	mongodb.LedgerCollection = mt.Client.Database("Fortune_Tracker").Collection("Ledger")
+	mongodb.TransactionCollection = mt.Client.Database("Fortune_Tracker").Collection("Transaction")
+}
+
+// Names of the commands sent, with the collection they were sent to
+func commandNames(mt *mtest.T) []string {
+	var names []string
+	for _, e := range mt.GetAllStartedEvents() {
+		coll, _ := e.Command.Lookup(e.CommandName).StringValueOK()
+		names = append(names, e.CommandName+" "+coll)
+	}
+	return names
+}
+
+// First update statement of an update command
+func updateStatement(e *event.CommandStartedEvent) bson.Raw {
+	return e.Command.Lookup("updates").Array().Index(0).Value().Document()
+}
+
+func TestDeleteParentTypeMergedMovesOnlyKnownChildTypes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("merge", func(mt *mtest.T) {
+		setupMockCollections(mt)
+		mt.AddMockResponses(matched, matched)
+
+		if err := deleteParentType(typesTestLedger(), 1, 2); err != nil {
+			mt.Fatal(err)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 2 {
+			mt.Fatalf("commands %v, want the types saved then the transactions moved", commandNames(mt))
+		}
+
+		// Lunch and Dinner become 2 and 3 of Transport, over the version read
+		saved := updateStatement(events[0])
+		if version := saved.Lookup("q", "Types.Version").Int32(); version != 4 {
+			mt.Fatalf("types saved over version %d, want 4", version)
+		}
+		var types ledgerType
+		if err := saved.Lookup("u", "$set", "Types").Unmarshal(&types); err != nil {
+			mt.Fatal(err)
+		}
+		if len(types.ParentTypes) != 1 || len(types.ParentTypes[0].ChildTypes) != 3 || types.Version != 5 {
+			mt.Fatalf("saved types %+v", types)
+		}
+
+		// Only transactions of the moved child types are selected, others keep their IDs
+		moved := updateStatement(events[1])
+		in, err := moved.Lookup("q", "Type.ChildType", "$in").Array().Values()
+		if err != nil || len(in) != 2 || in[0].Int32() != 1 || in[1].Int32() != 2 {
+			mt.Fatalf("moved child types %s", moved.Lookup("q"))
+		}
+		stage := moved.Lookup("u").Array().Index(0).Value().Document()
+		if parent := stage.Lookup("$set", "Type.ParentType").Int32(); parent != 2 {
+			mt.Fatalf("moved to parent type %d, want 2", parent)
+		}
+		if def := stage.Lookup("$set", "Type.ChildType", "$switch", "default").StringValue(); def != "$Type.ChildType" {
+			mt.Fatalf("child types without a branch are set to %s", def)
+		}
+	})
+}
+
+func TestDeleteUnusedParentType(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unused", func(mt *mtest.T) {
+		setupMockCollections(mt)
+		mt.AddMockResponses(matched, countResponse(0))
+
+		if err := deleteParentType(typesTestLedger(), 1, 0); err != nil {
+			mt.Fatal(err)
+		}
+		if names := commandNames(mt); len(names) != 2 || names[0] != "update Ledger" || names[1] != "aggregate Transaction" {
+			mt.Fatalf("commands %v, want the types saved then the transactions counted", names)
+		}
+	})
+
+	mt.Run("used", func(mt *mtest.T) {
+		setupMockCollections(mt)
+		mt.AddMockResponses(matched, countResponse(2), matched)
+
+		err := deleteParentType(typesTestLedger(), 1, 0)
+		if err == nil || err.Error() != "type is used by transactions" {
+			mt.Fatalf("got %v, want type is used by transactions", err)
+		}
+
+		// The deletion is undone over the version it saved
+		events := mt.GetAllStartedEvents()
+		if len(events) != 3 {
+			mt.Fatalf("commands %v, want the deletion undone", commandNames(mt))
+		}
+		restored := updateStatement(events[2])
+		if version := restored.Lookup("q", "Types.Version").Int32(); version != 5 {
+			mt.Fatalf("types restored over version %d, want 5", version)
+		}
+		var types ledgerType
+		if err = restored.Lookup("u", "$set", "Types").Unmarshal(&types); err != nil {
+			mt.Fatal(err)
+		}
+		if len(types.ParentTypes) != 2 || types.ParentTypes[0].PTID != 1 || len(types.ParentTypes[0].ChildTypes) != 2 {
+			mt.Fatalf("restored types %+v", types)
+		}
+	})
+
+	mt.Run("changed concurrently", func(mt *mtest.T) {
+		setupMockCollections(mt)
+		mt.AddMockResponses(notMatched)
+
+		err := deleteParentType(typesTestLedger(), 1, 0)
+		if err == nil || err.Error() != "types were changed concurrently" {
+			mt.Fatalf("got %v, want types were changed concurrently", err)
+		}
+		if names := commandNames(mt); len(names) != 1 {
+			mt.Fatalf("commands %v, want only the rejected save", names)
+		}
+	})
+}
+
+func TestDeleteUsedChildType(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("used", func(mt *mtest.T) {
+		setupMockCollections(mt)
+		mt.AddMockResponses(matched, countResponse(1), matched)
+
+		err := deleteChildType(typesTestLedger(), 1, 2, 0, 0)
+		if err == nil || err.Error() != "type is used by transactions" {
+			mt.Fatalf("got %v, want type is used by transactions", err)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 3 {
+			mt.Fatalf("commands %v, want the deletion undone", commandNames(mt))
+		}
+		count := events[1].Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match")
+		if ct, _ := count.Document().Lookup("Type.ChildType").Int32OK(); ct != 2 {
+			mt.Fatalf("counted %s, want the transactions of child type 2", count)
+		}
+		var types ledgerType
+		if err = updateStatement(events[2]).Lookup("u", "$set", "Types").Unmarshal(&types); err != nil {
+			mt.Fatal(err)
+		}
+		if len(types.ParentTypes[0].ChildTypes) != 2 {
+			mt.Fatalf("restored types %+v", types)
+		}
+	})
+}
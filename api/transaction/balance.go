@@ -0,0 +1,159 @@
+package transaction
+
+import (
+	"Fortune_Tracker_API/internal/money"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mongodb"
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type memberBalance struct {
+	UUID string `json:"UUID"`
+	// Paid minus consumed, positive when the others owe the member
+	Balance money.Amount `json:"Balance"`
+}
+
+type debt struct {
+	From   string       `json:"From"`
+	To     string       `json:"To"`
+	Amount money.Amount `json:"Amount"`
+}
+
+type balances struct {
+	Balances []memberBalance `json:"Balances"`
+	Debts    []debt          `json:"Debts"`
+}
+
+// Amount a sharer owes the payer, summed over the transactions of the ledger
+type pairSum struct {
+	Payer  string       `bson:"Payer"`
+	Sharer string       `bson:"Sharer"`
+	Amount money.Amount `bson:"Amount"`
+}
+
+// Sum what every sharer owes every payer in the ledger, up to asOf (RecordTime, 0 for all transactions).
+// The payer of an expense advanced the sharers' parts, the payer of an income received them;
+// a settlement counts as an expense of the member paying back for the member paid back.
+// Transfers move money between accounts and are not shared.
+func sumPairs(ULID string, asOf uint32) ([]pairSum, error) {
+	var pairs []pairSum
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	match := bson.M{
+		"ULID":        ULID,
+		"Type.Action": bson.M{"$in": bson.A{"expense", "income", actionSettlement}},
+	}
+	if asOf != 0 {
+		match["RecordTime"] = bson.M{"$lte": asOf}
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$unwind": "$Sharers"},
+		// Amounts are summed as Decimal128, also the ones stored as doubles before amounts were exact
+		bson.M{"$project": bson.M{
+			"Payer":  "$Payer",
+			"Sharer": "$Sharers.UUID",
+			"Amount": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$Type.Action", "income"}},
+				bson.M{"$multiply": bson.A{bson.M{"$toDecimal": "$Sharers.Amount"}, -1}},
+				bson.M{"$toDecimal": "$Sharers.Amount"},
+			}},
+		}},
+		// The payer's own part is neither owed nor lent
+		bson.M{"$match": bson.M{"$expr": bson.M{"$ne": bson.A{"$Payer", "$Sharer"}}}},
+		bson.M{"$group": bson.M{
+			"_id":    bson.M{"Payer": "$Payer", "Sharer": "$Sharer"},
+			"Amount": bson.M{"$sum": "$Amount"},
+		}},
+		bson.M{"$project": bson.M{
+			"_id":    0,
+			"Payer":  "$_id.Payer",
+			"Sharer": "$_id.Sharer",
+			"Amount": 1,
+		}},
+	}
+
+	cursor, err := mongodb.TransactionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logger.Error("[TRANSACTION] " + err.Error())
+		return pairs, err
+	}
+
+	if err = cursor.All(ctx, &pairs); err != nil {
+		logger.Error("[TRANSACTION] " + err.Error())
+		return pairs, err
+	}
+
+	return pairs, nil
+}
+
+// Compute the net balance of every member and who owes whom in the ledger
+func getBalances(ULID string, members map[string]bool, asOf uint32) (balances, error) {
+	result := balances{Balances: []memberBalance{}, Debts: []debt{}}
+
+	pairs, err := sumPairs(ULID, asOf)
+	if err != nil {
+		return result, err
+	}
+
+	// Every member is listed, also the ones without transactions
+	net := make(map[string]money.Amount)
+	for UUID := range members {
+		net[UUID] = money.Amount{}
+	}
+
+	// Debts between two members are netted against each other
+	owed := make(map[[2]string]money.Amount)
+	for _, p := range pairs {
+		if net[p.Payer], err = net[p.Payer].Add(p.Amount); err != nil {
+			logger.Error("[TRANSACTION] Balance of: " + p.Payer + " " + err.Error())
+			return result, err
+		}
+		if net[p.Sharer], err = net[p.Sharer].Sub(p.Amount); err != nil {
+			logger.Error("[TRANSACTION] Balance of: " + p.Sharer + " " + err.Error())
+			return result, err
+		}
+		if p.Sharer < p.Payer {
+			key := [2]string{p.Sharer, p.Payer}
+			owed[key], err = owed[key].Add(p.Amount)
+		} else {
+			key := [2]string{p.Payer, p.Sharer}
+			owed[key], err = owed[key].Sub(p.Amount)
+		}
+		if err != nil {
+			logger.Error("[TRANSACTION] Debt between: " + p.Payer + " and " + p.Sharer + " " + err.Error())
+			return result, err
+		}
+	}
+
+	for UUID, balance := range net {
+		result.Balances = append(result.Balances, memberBalance{UUID: UUID, Balance: balance})
+	}
+	sort.Slice(result.Balances, func(i, j int) bool { return result.Balances[i].UUID < result.Balances[j].UUID })
+
+	// owed[{a, b}] > 0 means a owes b
+	for pair, amount := range owed {
+		if amount.Sign() > 0 {
+			result.Debts = append(result.Debts, debt{From: pair[0], To: pair[1], Amount: amount})
+		} else if amount.Sign() < 0 {
+			result.Debts = append(result.Debts, debt{From: pair[1], To: pair[0], Amount: amount.Neg()})
+		}
+	}
+	sort.Slice(result.Debts, func(i, j int) bool {
+		if result.Debts[i].From != result.Debts[j].From {
+			return result.Debts[i].From < result.Debts[j].From
+		}
+		return result.Debts[i].To < result.Debts[j].To
+	})
+
+	logger.Info("[TRANSACTION] Balances of ledger: " + ULID + " computed")
+
+	return result, nil
+}
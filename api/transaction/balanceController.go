@@ -0,0 +1,72 @@
+package transaction
+
+import (
+	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/internal/response"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Parse the optional ?asOf= query (unix time), returns false (and responds) if it is invalid
+func parseAsOf(c *gin.Context, r *response.Response) (uint32, bool) {
+	if c.Query("asOf") == "" {
+		return 0, true
+	}
+	value, err := strconv.ParseUint(c.Query("asOf"), 10, 32)
+	if err != nil || value == 0 {
+		r.Message = "asOf should be a unix time"
+		c.JSON(http.StatusBadRequest, r)
+		return 0, false
+	}
+	return uint32(value), true
+}
+
+// Get the net balance of every member and the debts between them, optionally as of ?asOf= (unix time)
+func GetBalances(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	asOf, ok := parseAsOf(c, r)
+	if !ok {
+		return
+	}
+
+	// Compute balances
+	result, err := getBalances(c.Param("ulid"), ledger.ContextMembers(c), asOf)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = result
+	c.JSON(http.StatusOK, r)
+}
+
+// Get the fewest payments settling every balance, optionally as of ?asOf= (unix time)
+func GetSettleUp(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	asOf, ok := parseAsOf(c, r)
+	if !ok {
+		return
+	}
+
+	// Compute payments
+	result, err := getSettleUp(c.Param("ulid"), ledger.ContextCurrency(c), ledger.ContextMembers(c), asOf)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = result
+	c.JSON(http.StatusOK, r)
+}
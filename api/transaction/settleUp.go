@@ -0,0 +1,190 @@
+package transaction
+
+import (
+	"Fortune_Tracker_API/internal/money"
+	"Fortune_Tracker_API/pkg/logger"
+	"math/bits"
+	"sort"
+)
+
+// Above this many members with a balance the exact search is too slow and payments are found greedily
+const maxExactSettleUpMembers = 16
+
+type payment struct {
+	From   string       `json:"From"`
+	To     string       `json:"To"`
+	Amount money.Amount `json:"Amount"`
+}
+
+type settleUp struct {
+	Currency string    `json:"Currency"`
+	Payments []payment `json:"Payments"`
+}
+
+// Balance of a member in minor units of the currency
+type unitBalance struct {
+	UUID  string
+	Units int64
+}
+
+type unitPayment struct {
+	From  string
+	To    string
+	Units int64
+}
+
+// Round the balances to minor units. Rounding can leave the total a few units off zero,
+// the difference is taken by the member with the largest balance so the payments still add up.
+func toUnits(bs []memberBalance, digits int) []unitBalance {
+	units := make([]unitBalance, 0, len(bs))
+	var total int64
+	for _, b := range bs {
+		u := b.Balance.Units(digits)
+		units = append(units, unitBalance{UUID: b.UUID, Units: u})
+		total += u
+	}
+
+	if total != 0 && len(units) > 0 {
+		largest := 0
+		for i, u := range units {
+			if abs(u.Units) > abs(units[largest].Units) {
+				largest = i
+			}
+		}
+		units[largest].Units -= total
+	}
+
+	// Members already settled need no payment
+	nonzero := units[:0]
+	for _, u := range units {
+		if u.Units != 0 {
+			nonzero = append(nonzero, u)
+		}
+	}
+	sort.Slice(nonzero, func(i, j int) bool { return nonzero[i].UUID < nonzero[j].UUID })
+	return nonzero
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Settle a group of balances adding up to zero, the largest debtor pays the largest creditor
+// until everyone is settled (ties are broken by UUID). Takes at most len(group)-1 payments.
+func settleGreedy(group []unitBalance) []unitPayment {
+	var payments []unitPayment
+	bs := append([]unitBalance(nil), group...)
+
+	for {
+		debtor, creditor := -1, -1
+		for i, b := range bs {
+			if b.Units < 0 && (debtor == -1 || b.Units < bs[debtor].Units) {
+				debtor = i
+			} else if b.Units > 0 && (creditor == -1 || b.Units > bs[creditor].Units) {
+				creditor = i
+			}
+		}
+		if debtor == -1 || creditor == -1 {
+			return payments
+		}
+
+		amount := -bs[debtor].Units
+		if bs[creditor].Units < amount {
+			amount = bs[creditor].Units
+		}
+		payments = append(payments, unitPayment{From: bs[debtor].UUID, To: bs[creditor].UUID, Units: amount})
+		bs[debtor].Units += amount
+		bs[creditor].Units -= amount
+	}
+}
+
+// Split the balances into the largest number of groups adding up to zero.
+// Every group of k members can be settled with k-1 payments and no fewer, so n members
+// split into g groups need n-g payments, which is the minimum when g is the largest possible.
+// dp[mask] is the largest number of zero-sum groups the members in mask can be split into
+// when they are removed one by one, which is found for all 2^n subsets.
+func zeroSumGroups(bs []unitBalance) [][]unitBalance {
+	n := len(bs)
+	full := 1<<n - 1
+
+	sum := make([]int64, full+1)
+	for mask := 1; mask <= full; mask++ {
+		low := bits.TrailingZeros(uint(mask))
+		sum[mask] = sum[mask&(mask-1)] + bs[low].Units
+	}
+
+	dp := make([]int8, full+1)
+	choice := make([]int8, full+1)
+	for mask := 1; mask <= full; mask++ {
+		best := int8(-1)
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && dp[mask^(1<<i)] > best {
+				best, choice[mask] = dp[mask^(1<<i)], int8(i)
+			}
+		}
+		dp[mask] = best
+		if sum[mask] == 0 {
+			dp[mask]++
+		}
+	}
+
+	// Walk back the removals, every zero-sum subset passed closes a group
+	var groups [][]unitBalance
+	mask, groupStart := full, full
+	for mask != 0 {
+		mask ^= 1 << choice[mask]
+		if sum[mask] == 0 {
+			var group []unitBalance
+			for i := 0; i < n; i++ {
+				if (groupStart^mask)&(1<<i) != 0 {
+					group = append(group, bs[i])
+				}
+			}
+			groups = append(groups, group)
+			groupStart = mask
+		}
+	}
+	return groups
+}
+
+// Find the fewest payments settling the balances (sorted by UUID), ordered by payer then payee
+func settlePayments(units []unitBalance) []unitPayment {
+	var payments []unitPayment
+	if len(units) <= maxExactSettleUpMembers {
+		for _, group := range zeroSumGroups(units) {
+			payments = append(payments, settleGreedy(group)...)
+		}
+	} else {
+		payments = settleGreedy(units)
+	}
+
+	sort.SliceStable(payments, func(i, j int) bool {
+		if payments[i].From != payments[j].From {
+			return payments[i].From < payments[j].From
+		}
+		return payments[i].To < payments[j].To
+	})
+	return payments
+}
+
+// Compute the fewest payments settling every balance of the ledger, rounded to the currency's minor unit
+func getSettleUp(ULID, currency string, members map[string]bool, asOf uint32) (settleUp, error) {
+	result := settleUp{Currency: currency, Payments: []payment{}}
+
+	bs, err := getBalances(ULID, members, asOf)
+	if err != nil {
+		return result, err
+	}
+
+	digits := money.MinorUnits(currency)
+	for _, p := range settlePayments(toUnits(bs.Balances, digits)) {
+		result.Payments = append(result.Payments, payment{From: p.From, To: p.To, Amount: money.FromUnits(p.Units, digits)})
+	}
+
+	logger.Info("[TRANSACTION] Settle up of ledger: " + ULID + " computed")
+
+	return result, nil
+}
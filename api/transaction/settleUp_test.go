@@ -0,0 +1,201 @@
+package transaction
+
+import (
+	"Fortune_Tracker_API/internal/money"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func balancesOf(t *testing.T, amounts map[string]string) []memberBalance {
+	t.Helper()
+	var bs []memberBalance
+	for UUID, amount := range amounts {
+		a, err := money.Parse(amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs = append(bs, memberBalance{UUID: UUID, Balance: a})
+	}
+	return bs
+}
+
+// Check the payments bring every balance to zero
+func checkSettled(t *testing.T, units []unitBalance, payments []unitPayment) {
+	t.Helper()
+	left := make(map[string]int64)
+	for _, u := range units {
+		left[u.UUID] = u.Units
+	}
+	for _, p := range payments {
+		if p.Units <= 0 || p.From == p.To {
+			t.Fatalf("invalid payment %+v", p)
+		}
+		left[p.From] += p.Units
+		left[p.To] -= p.Units
+	}
+	for UUID, units := range left {
+		if units != 0 {
+			t.Fatalf("%s is left with %d after %+v", UUID, units, payments)
+		}
+	}
+}
+
+func TestToUnits(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[string]string
+		digits   int
+		want     []unitBalance
+	}{
+		{"exact balances",
+			map[string]string{"carol": "-12.5", "alice": "10", "bob": "2.5"}, 2,
+			[]unitBalance{{"alice", 1000}, {"bob", 250}, {"carol", -1250}}},
+		{"settled members are left out",
+			map[string]string{"alice": "5", "bob": "0", "carol": "-5", "dave": "0.004"}, 2,
+			[]unitBalance{{"alice", 500}, {"carol", -500}}},
+		// 0.005 + 0.005 rounds to 2 cents against 1, the largest balance (the first on ties) takes the cent
+		{"rounding residue taken by the largest balance",
+			map[string]string{"alice": "0.005", "bob": "0.005", "carol": "-0.01"}, 2,
+			[]unitBalance{{"bob", 1}, {"carol", -1}}},
+		// 100 / 3 each, paid by one member
+		{"residue of a three way split",
+			map[string]string{"alice": "66.666667", "bob": "-33.333333", "carol": "-33.333334"}, 0,
+			[]unitBalance{{"alice", 66}, {"bob", -33}, {"carol", -33}}},
+		// Three halves round to 3 against 2
+		{"residue on a debtor",
+			map[string]string{"a": "0.5", "b": "0.5", "c": "0.5", "d": "-1.5"}, 0,
+			[]unitBalance{{"a", 1}, {"b", 1}, {"c", 1}, {"d", -3}}},
+	}
+	for _, tt := range tests {
+		got := toUnits(balancesOf(t, tt.balances), tt.digits)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: toUnits = %v, want %v", tt.name, got, tt.want)
+		}
+		var total int64
+		for _, u := range got {
+			total += u.Units
+		}
+		if total != 0 {
+			t.Errorf("%s: units add up to %d", tt.name, total)
+		}
+	}
+}
+
+func TestSettlePaymentsMinimal(t *testing.T) {
+	tests := []struct {
+		name  string
+		units []unitBalance
+		want  []unitPayment
+	}{
+		{"nothing to settle", nil, nil},
+		{"one debtor",
+			[]unitBalance{{"alice", 30}, {"bob", -10}, {"carol", -20}},
+			[]unitPayment{{"bob", "alice", 10}, {"carol", "alice", 20}}},
+		// Greedy takes 4 payments (carol pays alice first), carol and bob settle apart from the others
+		{"exact search beats greedy",
+			[]unitBalance{{"alice", 16}, {"bob", 9}, {"carol", -9}, {"dave", -8}, {"erin", -8}},
+			[]unitPayment{{"carol", "bob", 9}, {"dave", "alice", 8}, {"erin", "alice", 8}}},
+		{"zero-sum subset",
+			[]unitBalance{{"alice", 5}, {"bob", 5}, {"carol", -3}, {"dave", -7}, {"erin", 3}, {"frank", -3}},
+			nil},
+	}
+	for _, tt := range tests {
+		got := settlePayments(tt.units)
+		checkSettled(t, tt.units, got)
+		if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: payments %v, want %v", tt.name, got, tt.want)
+		}
+
+		// n members in g zero-sum groups need n-g payments
+		groups := zeroSumGroups(tt.units)
+		if len(got) != len(tt.units)-len(groups) {
+			t.Errorf("%s: %d payments for %d members in %d groups", tt.name, len(got), len(tt.units), len(groups))
+		}
+	}
+
+	greedy := settleGreedy([]unitBalance{{"alice", 16}, {"bob", 9}, {"carol", -9}, {"dave", -8}, {"erin", -8}})
+	if len(greedy) != 4 {
+		t.Fatalf("greedy settles the example in %d payments, the example no longer shows the exact search winning", len(greedy))
+	}
+}
+
+func TestZeroSumGroups(t *testing.T) {
+	units := []unitBalance{{"alice", 5}, {"bob", 5}, {"carol", -3}, {"dave", -7}, {"erin", 3}, {"frank", -3}}
+
+	// {carol, erin} or {erin, frank} settle apart, the other four can not be split further
+	groups := zeroSumGroups(units)
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		var sum int64
+		for _, u := range group {
+			if seen[u.UUID] {
+				t.Fatalf("%s is in two groups: %v", u.UUID, groups)
+			}
+			seen[u.UUID] = true
+			sum += u.Units
+		}
+		if sum != 0 {
+			t.Fatalf("group %v adds up to %d", group, sum)
+		}
+	}
+	if len(seen) != len(units) {
+		t.Fatalf("groups %v leave members out", groups)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("%d groups: %v, want 2", len(groups), groups)
+	}
+}
+
+func TestSettlePaymentsOrderIsStable(t *testing.T) {
+	want := settlePayments(toUnits(balancesOf(t, map[string]string{
+		"alice": "16", "bob": "9", "carol": "-9", "dave": "-8", "erin": "-8", "frank": "4", "gina": "-4",
+	}), 0))
+
+	// Maps are iterated in a random order, the balances reach toUnits in a different order every time
+	for i := 0; i < 20; i++ {
+		got := settlePayments(toUnits(balancesOf(t, map[string]string{
+			"alice": "16", "bob": "9", "carol": "-9", "dave": "-8", "erin": "-8", "frank": "4", "gina": "-4",
+		}), 0))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("payments %v, then %v", want, got)
+		}
+	}
+	for i := 1; i < len(want); i++ {
+		if want[i-1].From > want[i].From || (want[i-1].From == want[i].From && want[i-1].To > want[i].To) {
+			t.Fatalf("payments are not ordered by payer then payee: %v", want)
+		}
+	}
+}
+
+func TestSettlePaymentsGreedyAboveExactLimit(t *testing.T) {
+	// Copies of the example greedy does not settle minimally, past the exact search limit
+	var units []unitBalance
+	copies := 0
+	for ; len(units) <= maxExactSettleUpMembers; copies++ {
+		for _, u := range []unitBalance{{"alice", 16}, {"bob", 9}, {"carol", -9}, {"dave", -8}, {"erin", -8}} {
+			units = append(units, unitBalance{UUID: fmt.Sprintf("%s%02d", u.UUID, copies), Units: u.Units})
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].UUID < units[j].UUID })
+
+	got := settlePayments(units)
+	checkSettled(t, units, got)
+
+	want := settleGreedy(units)
+	sort.SliceStable(want, func(i, j int) bool {
+		if want[i].From != want[j].From {
+			return want[i].From < want[j].From
+		}
+		return want[i].To < want[j].To
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("payments %v, want the greedy ones %v", got, want)
+	}
+
+	// Every copy splits into 2 zero-sum groups, the exact search would need 3 payments per copy
+	if len(got) <= 3*copies {
+		t.Fatalf("%d payments, the example no longer shows the greedy search is used", len(got))
+	}
+}
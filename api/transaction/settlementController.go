@@ -0,0 +1,96 @@
+package transaction
+
+import (
+	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/internal/money"
+	"Fortune_Tracker_API/internal/response"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A payment of the settle-up suggestions, or any payment between two members
+type settlementRequest struct {
+	From   string       `json:"From" binding:"required"`
+	To     string       `json:"To" binding:"required"`
+	Amount money.Amount `json:"Amount" binding:"required"`
+	// Optional, default "Settlement" and now
+	Name       string `json:"Name"`
+	RecordTime uint32 `json:"RecordTime"`
+}
+
+// Record a member paying back another one
+func CreateSettlement(c *gin.Context) {
+	var err error
+	var UTID string
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var settlementRequest settlementRequest
+	if err = c.ShouldBindJSON(&settlementRequest); err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check pass in fields
+	digits := money.MinorUnits(ledger.ContextCurrency(c))
+	if settlementRequest.Amount.Sign() <= 0 {
+		r.Message = "amount should be positive"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	} else if settlementRequest.Amount.Decimals() > digits {
+		r.Message = "amount should have at most " + strconv.Itoa(digits) + " decimals"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	} else if settlementRequest.From == settlementRequest.To {
+		r.Message = "a settlement should be paid to another member"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	now := uint32((uint64(time.Now().Unix()) << 32 >> 32))
+	if settlementRequest.RecordTime > now {
+		r.Message = "record time should be in the past"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	} else if settlementRequest.RecordTime == 0 {
+		settlementRequest.RecordTime = now
+	}
+	if settlementRequest.Name == "" {
+		settlementRequest.Name = "Settlement"
+	}
+
+	// The member paying back is the payer, the member paid back the only sharer
+	transaction := transaction{
+		ULID:       c.Param("ulid"),
+		Amount:     settlementRequest.Amount,
+		RecordTime: settlementRequest.RecordTime,
+		UpdateTime: now,
+		Type:       transactionType{Action: actionSettlement},
+		Name:       settlementRequest.Name,
+		Payer:      settlementRequest.From,
+		Sharers:    []transactionSharer{{UUID: settlementRequest.To, Amount: settlementRequest.Amount}},
+	}
+
+	// Create transaction
+	if UTID, err = create(transaction, ledger.ContextSettlementMembers(c), ledger.ContextCategories(c)); err != nil {
+		r.Message = err.Error()
+		if strings.Contains(err.Error(), "is not a member of the ledger") {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = response.UTIDResponse{UTID: UTID}
+	c.JSON(http.StatusCreated, r)
+}
@@ -1,7 +1,7 @@
 package transaction
 
 import (
-	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/internal/money"
 	"Fortune_Tracker_API/pkg/logger"
 	"Fortune_Tracker_API/pkg/mongodb"
 	"context"
@@ -13,21 +13,25 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Settlements record a member paying back another one, they have no category
+const actionSettlement = "settlement"
+
 type transactionType struct {
-	Action     string `json:"Action" bson:"Action" binding:"required"`
-	ParentType uint8  `json:"ParentType" bson:"ParentType" binding:"required"`
-	ChildType  uint8  `json:"ChildType" bson:"ChildType" binding:"required"`
+	Action string `json:"Action" bson:"Action" binding:"required"`
+	// Required for every action except settlements
+	ParentType uint8 `json:"ParentType" bson:"ParentType"`
+	ChildType  uint8 `json:"ChildType" bson:"ChildType"`
 }
 
 type transactionSharer struct {
-	UUID   string  `json:"UUID" bson:"UUID" binding:"required"`
-	Amount float64 `json:"Amount" bson:"Amount" binding:"required"`
+	UUID   string       `json:"UUID" bson:"UUID" binding:"required"`
+	Amount money.Amount `json:"Amount" bson:"Amount" binding:"required"`
 }
 
 type transaction struct {
 	UTID       string              `json:"UTID" bson:"UTID"`
 	ULID       string              `json:"ULID" bson:"ULID"`
-	Amount     float64             `json:"Amount" bson:"Amount" binding:"required"`
+	Amount     money.Amount        `json:"Amount" bson:"Amount" binding:"required"`
 	RecordTime uint32              `json:"RecordTime" bson:"RecordTime" binding:"required"`
 	UpdateTime uint32              `json:"UpdateTime" bson:"UpdateTime" binding:"required"`
 	Type       transactionType     `json:"Type" bson:"Type"`
@@ -36,30 +40,44 @@ type transaction struct {
 	Sharers    []transactionSharer `json:"Sharers" bson:"Sharers" binding:"required"`
 }
 
-func create(ts transaction, UUID string) (string, error) {
-	// Get members of ledger
-	var err error
-	var members map[string]bool
-	if members, err = ledger.GetLedgerMember(ts.ULID); err != nil {
-		return "", err
-	} 
+// Check the category of the transaction exists in the ledger
+func checkCategory(ts transaction, categories map[int]map[int]bool) error {
+	if ts.Type.Action == actionSettlement {
+		return nil
+	}
+	if !categories[int(ts.Type.ParentType)][int(ts.Type.ChildType)] {
+		logger.Warn("[TRANSACTION] Category does not exist in the ledger")
+		return errors.New("category does not exist in the ledger")
+	}
+	return nil
+}
 
+// Check the users of the transaction are members of the ledger
+func checkMembers(ts transaction, members map[string]bool) error {
 	// These users should be the member of the ledger
-	// -> UUID in token, payer, all user in sharers
-	if !members[UUID] {
-		logger.Warn("[TRANSACTION] User is not a member of the ledger")
-		return "", errors.New("user is not a member of the ledger")
-	} else if !members[ts.Payer] {
+	// -> payer, all user in sharers (the caller is checked by ledger.CheckMembership)
+	if !members[ts.Payer] {
 		logger.Warn("[TRANSACTION] Payer is not a member of the ledger")
-		return "", errors.New("payer is not a member of the ledger")
-	} 
+		return errors.New("payer is not a member of the ledger")
+	}
 
 	for _, sharer := range ts.Sharers {
 		if !members[sharer.UUID] {
 			logger.Warn("[TRANSACTION] A sharer is not a member of the ledger")
-			return "", errors.New("a sharer is not a member of the ledger")
+			return errors.New("a sharer is not a member of the ledger")
 		}
 	}
+	return nil
+}
+
+func create(ts transaction, members map[string]bool, categories map[int]map[int]bool) (string, error) {
+	var err error
+	if err = checkMembers(ts, members); err != nil {
+		return "", err
+	}
+	if err = checkCategory(ts, categories); err != nil {
+		return "", err
+	}
 
 	// Generate UTID
 	ts.UTID = uuid.New().String()
@@ -79,24 +97,13 @@ func create(ts transaction, UUID string) (string, error) {
 	return ts.UTID, nil
 }
 
-func deleteT(ULID, UTID, UUID string) error {
-	// Check the user is in the ledger of the transaction
-	var err error
-	var members map[string]bool
-	if members, err = ledger.GetLedgerMember(ULID); err != nil {
-		return err
-	} 
-
-	if !members[UUID] {
-		logger.Warn("[TRANSACTION] User is not a member of the ledger")
-		return errors.New("user is not a member of the ledger")
-	}
-
+func deleteT(ULID, UTID string) error {
 	// Delete the transaction from mongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"UTID": UTID}
+	// Only match the transaction in the ledger of the path, others are reported as not found
+	filter := bson.M{"UTID": UTID, "ULID": ULID}
 
 	result, err := mongodb.TransactionCollection.DeleteOne(ctx, filter)
 	if err != nil {
@@ -112,27 +119,17 @@ func deleteT(ULID, UTID, UUID string) error {
 	return nil
 }
 
-func get(ULID, UTID, UUID string) (transaction, error) {
-	// Check the user is in the ledger of the transaction
-	var err error
+func get(ULID, UTID string) (transaction, error) {
 	var ts transaction
-	var members map[string]bool
-	if members, err = ledger.GetLedgerMember(ULID); err != nil {
-		return ts, err
-	} 
-
-	if !members[UUID] {
-		logger.Warn("[TRANSACTION] User is not a member of the ledger")
-		return ts, errors.New("user is not a member of the ledger")
-	}
 
 	// Get the transaction from mongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"UTID": UTID}
+	// Only match the transaction in the ledger of the path, others are reported as not found
+	filter := bson.M{"UTID": UTID, "ULID": ULID}
 
-	err = mongodb.TransactionCollection.FindOne(ctx, filter).Decode(&ts)
+	err := mongodb.TransactionCollection.FindOne(ctx, filter).Decode(&ts)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			logger.Warn("[TRANSACTION] Transaction not found")
@@ -148,19 +145,8 @@ func get(ULID, UTID, UUID string) (transaction, error) {
 }
 
 // get the transaction between startTime and endTime in the given ledger
-func getByTime(gbtr getByTimeRequest, UUID string) ([]transaction, error) {
-	// Check the user is in the ledger of the transaction
-	var err error
+func getByTime(gbtr getByTimeRequest) ([]transaction, error) {
 	var tss []transaction
-	var members map[string]bool
-	if members, err = ledger.GetLedgerMember(gbtr.ULID); err != nil {
-		return tss, err
-	} 
-
-	if !members[UUID] {
-		logger.Warn("[TRANSACTION] User is not a member of the ledger")
-		return tss, errors.New("user is not a member of the ledger")
-	}
 
 	// Get the transaction from mongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -173,6 +159,10 @@ func getByTime(gbtr getByTimeRequest, UUID string) ([]transaction, error) {
 			"$lte": gbtr.EndTime,
 		},
 	}
+	// Settlements are not spending, reports leave them out unless asked for
+	if !gbtr.IncludeSettlements {
+		filter["Type.Action"] = bson.M{"$ne": actionSettlement}
+	}
 
 	cursor, err := mongodb.TransactionCollection.Find(ctx, filter)
 	if err != nil {
@@ -190,25 +180,22 @@ func getByTime(gbtr getByTimeRequest, UUID string) ([]transaction, error) {
 	return tss, nil
 }
 
-func update(UUID string, ts transaction) error {
-	// Check the user is in the ledger of the transaction
-	var err error
-	var members map[string]bool
-	if members, err = ledger.GetLedgerMember(ts.ULID); err != nil {
+func update(ts transaction, members map[string]bool, categories map[int]map[int]bool) error {
+	if err := checkMembers(ts, members); err != nil {
+		return err
+	}
+	if err := checkCategory(ts, categories); err != nil {
 		return err
-	} 
-
-	if !members[UUID] {
-		logger.Warn("[TRANSACTION] User is not a member of the ledger")
-		return errors.New("user is not a member of the ledger")
 	}
 
 	// Get the transaction from mongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Only match the transaction in the ledger of the path, others are reported as not found
 	filter := bson.M{
 		"UTID": ts.UTID,
+		"ULID": ts.ULID,
 	}
 
 	update := bson.M{
@@ -236,3 +223,38 @@ func update(UUID string, ts transaction) error {
 
 	return nil
 }
+
+// Get the transactions of the ledger whose category does not exist in the ledger
+func getInconsistent(ULID string, categories map[int]map[int]bool) ([]transaction, error) {
+	tss := []transaction{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Exclude every existing category, settlements have none
+	filter := bson.M{"ULID": ULID, "Type.Action": bson.M{"$ne": actionSettlement}}
+	known := bson.A{}
+	for PTID, CTIDs := range categories {
+		for CTID := range CTIDs {
+			known = append(known, bson.M{"Type.ParentType": PTID, "Type.ChildType": CTID})
+		}
+	}
+	if len(known) > 0 {
+		filter["$nor"] = known
+	}
+
+	cursor, err := mongodb.TransactionCollection.Find(ctx, filter)
+	if err != nil {
+		logger.Error("[TRANSACTION] " + err.Error())
+		return tss, err
+	}
+
+	if err = cursor.All(ctx, &tss); err != nil {
+		logger.Error("[TRANSACTION] " + err.Error())
+		return tss, err
+	}
+
+	logger.Info("[TRANSACTION] Inconsistent transactions retrieved")
+
+	return tss, nil
+}
@@ -1,8 +1,11 @@
 package transaction
 
 import (
+	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/internal/money"
 	"Fortune_Tracker_API/internal/response"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +16,16 @@ type getByTimeRequest struct {
 	ULID      string `json:"ULID" bson:"ULID"`
 	StartTime uint32 `json:"StartTime" bson:"StartTime" binding:"required"`
 	EndTime   uint32 `json:"EndTime" bson:"EndTime" binding:"required"`
+	// Settlements are left out of reports by default
+	IncludeSettlements bool `json:"IncludeSettlements" bson:"IncludeSettlements"`
+}
+
+// Members the transaction can name, settlements can also name deleted accounts
+func contextMembers(c *gin.Context, action string) map[string]bool {
+	if action == actionSettlement {
+		return ledger.ContextSettlementMembers(c)
+	}
+	return ledger.ContextMembers(c)
 }
 
 func Create(c *gin.Context) {
@@ -31,38 +44,61 @@ func Create(c *gin.Context) {
 
 	transaction.ULID = c.Param("ulid")
 
-	// Amount should be positive
-	if transaction.Amount <= 0 {
+	// Amount should be positive, within the precision of the ledger currency
+	digits := money.MinorUnits(ledger.ContextCurrency(c))
+	if transaction.Amount.Sign() <= 0 {
 		r.Message = "amount should be positive"
 		c.JSON(http.StatusBadRequest, r)
 		return
+	} else if transaction.Amount.Decimals() > digits {
+		r.Message = "amount should have at most " + strconv.Itoa(digits) + " decimals"
+		c.JSON(http.StatusBadRequest, r)
+		return
 	}
-	totalAmount := 0.0
+	var totalAmount money.Amount
 	for _, sharer := range transaction.Sharers {
-		totalAmount += sharer.Amount
-		if sharer.Amount <= 0 {
+		if totalAmount, err = totalAmount.Add(sharer.Amount); err != nil {
+			r.Message = err.Error()
+			c.JSON(http.StatusBadRequest, r)
+			return
+		} else if sharer.Amount.Sign() <= 0 {
 			r.Message = "amount should be positive"
 			c.JSON(http.StatusBadRequest, r)
 			return
+		} else if sharer.Amount.Decimals() > digits {
+			r.Message = "amount should have at most " + strconv.Itoa(digits) + " decimals"
+			c.JSON(http.StatusBadRequest, r)
+			return
 		}
 	}
 
 	// Amount should be equal to the sum of sharers' amount
-	if totalAmount != transaction.Amount {
+	if totalAmount.Cmp(transaction.Amount) != 0 {
 		r.Message = "amount should be equal to the sum of sharers' amount"
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
-	// Type.Action should be "income" or "expense" or "transfer"
+	// Type.Action should be "income" or "expense" or "transfer" or "settlement"
 	if transaction.Type.Action != "income" &&
 		transaction.Type.Action != "expense" &&
-		transaction.Type.Action != "transfer" {
-		r.Message = "type.action should be income or expense or transfer"
+		transaction.Type.Action != "transfer" &&
+		transaction.Type.Action != actionSettlement {
+		r.Message = "type.action should be income or expense or transfer or settlement"
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
+	// Settlements are paid by a member to another one and have no category
+	if transaction.Type.Action == actionSettlement {
+		if len(transaction.Sharers) != 1 || transaction.Sharers[0].UUID == transaction.Payer {
+			r.Message = "a settlement should have one sharer other than the payer"
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		transaction.Type.ParentType, transaction.Type.ChildType = 0, 0
+	}
+
 	// Record and update time should be in the past
 	if transaction.RecordTime > uint32((uint64(time.Now().Unix())<<32>>32)) ||
 		transaction.UpdateTime > uint32((uint64(time.Now().Unix())<<32>>32)) {
@@ -72,12 +108,10 @@ func Create(c *gin.Context) {
 	}
 
 	// Create transaction
-	if UTID, err = create(transaction, c.MustGet("UUID").(string)); err != nil {
+	if UTID, err = create(transaction, contextMembers(c, transaction.Type.Action), ledger.ContextCategories(c)); err != nil {
 		r.Message = err.Error()
-		if err.Error() == "ledger not found" {
-			c.JSON(http.StatusNotFound, r)
-			return
-		} else if strings.Contains(err.Error(), "is not a member of the ledger") {
+		if strings.Contains(err.Error(), "is not a member of the ledger") ||
+			err.Error() == "category does not exist in the ledger" {
 			c.JSON(http.StatusBadRequest, r)
 			return
 		}
@@ -98,14 +132,11 @@ func Delete(c *gin.Context) {
 	r := response.New()
 
 	// Delete transaction
-	if err = deleteT(c.Param("ulid"), c.Param("utid"), c.MustGet("UUID").(string)); err != nil {
+	if err = deleteT(c.Param("ulid"), c.Param("utid")); err != nil {
 		r.Message = err.Error()
-		if err.Error() == "transaction not found" || err.Error() == "ledger not found" {
+		if err.Error() == "transaction not found" {
 			c.JSON(http.StatusNotFound, r)
 			return
-		} else if err.Error() == "user is not a member of the ledger" {
-			c.JSON(http.StatusBadRequest, r)
-			return
 		}
 		c.JSON(http.StatusInternalServerError, r)
 		return
@@ -124,14 +155,11 @@ func Get(c *gin.Context) {
 	r := response.New()
 
 	// Get transactions
-	if ts, err = get(c.Param("ulid"), c.Param("utid"), c.MustGet("UUID").(string)); err != nil {
+	if ts, err = get(c.Param("ulid"), c.Param("utid")); err != nil {
 		r.Message = err.Error()
-		if err.Error() == "transaction not found" || err.Error() == "ledger not found" {
+		if err.Error() == "transaction not found" {
 			c.JSON(http.StatusNotFound, r)
 			return
-		} else if err.Error() == "user is not a member of the ledger" {
-			c.JSON(http.StatusBadRequest, r)
-			return
 		}
 		c.JSON(http.StatusInternalServerError, r)
 		return
@@ -161,15 +189,8 @@ func GetByTime(c *gin.Context) {
 	gbtr.ULID = c.Param("ulid")
 
 	// Get transactions
-	if tss, err = getByTime(gbtr, c.MustGet("UUID").(string)); err != nil {
+	if tss, err = getByTime(gbtr); err != nil {
 		r.Message = err.Error()
-		if err.Error() == "ledger not found" {
-			c.JSON(http.StatusNotFound, r)
-			return
-		} else if err.Error() == "user is not a member of the ledger" {
-			c.JSON(http.StatusBadRequest, r)
-			return
-		}
 		c.JSON(http.StatusInternalServerError, r)
 		return
 	}
@@ -197,36 +218,59 @@ func Update(c *gin.Context) {
 	ts.ULID = c.Param("ulid")
 	ts.UTID = c.Param("utid")
 
-	// Amount should be positive
-	if ts.Amount <= 0 {
+	// Amount should be positive, within the precision of the ledger currency
+	digits := money.MinorUnits(ledger.ContextCurrency(c))
+	if ts.Amount.Sign() <= 0 {
 		r.Message = "amount should be positive"
 		c.JSON(http.StatusBadRequest, r)
 		return
+	} else if ts.Amount.Decimals() > digits {
+		r.Message = "amount should have at most " + strconv.Itoa(digits) + " decimals"
+		c.JSON(http.StatusBadRequest, r)
+		return
 	}
-	totalAmount := 0.0
+	var totalAmount money.Amount
 	for _, sharer := range ts.Sharers {
-		totalAmount += sharer.Amount
-		if sharer.Amount <= 0 {
+		if totalAmount, err = totalAmount.Add(sharer.Amount); err != nil {
+			r.Message = err.Error()
+			c.JSON(http.StatusBadRequest, r)
+			return
+		} else if sharer.Amount.Sign() <= 0 {
 			r.Message = "amount should be positive"
 			c.JSON(http.StatusBadRequest, r)
 			return
+		} else if sharer.Amount.Decimals() > digits {
+			r.Message = "amount should have at most " + strconv.Itoa(digits) + " decimals"
+			c.JSON(http.StatusBadRequest, r)
+			return
 		}
 	}
 
 	// Amount should be equal to the sum of sharers' amount
-	if totalAmount != ts.Amount {
+	if totalAmount.Cmp(ts.Amount) != 0 {
 		r.Message = "amount should be equal to the sum of sharers' amount"
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
-	// Type.Action should be "income" or "expense" or "transfer"
-	if ts.Type.Action != "income" && ts.Type.Action != "expense" && ts.Type.Action != "transfer" {
-		r.Message = "type.action should be income or expense or transfer"
+	// Type.Action should be "income" or "expense" or "transfer" or "settlement"
+	if ts.Type.Action != "income" && ts.Type.Action != "expense" &&
+		ts.Type.Action != "transfer" && ts.Type.Action != actionSettlement {
+		r.Message = "type.action should be income or expense or transfer or settlement"
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
+	// Settlements are paid by a member to another one and have no category
+	if ts.Type.Action == actionSettlement {
+		if len(ts.Sharers) != 1 || ts.Sharers[0].UUID == ts.Payer {
+			r.Message = "a settlement should have one sharer other than the payer"
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		ts.Type.ParentType, ts.Type.ChildType = 0, 0
+	}
+
 	// Record and update time should be in the past
 	if ts.RecordTime > uint32((uint64(time.Now().Unix())<<32>>32)) ||
 		ts.UpdateTime > uint32((uint64(time.Now().Unix())<<32>>32)) {
@@ -236,12 +280,13 @@ func Update(c *gin.Context) {
 	}
 
 	// Update transactions
-	if err = update(c.MustGet("UUID").(string), ts); err != nil {
+	if err = update(ts, contextMembers(c, ts.Type.Action), ledger.ContextCategories(c)); err != nil {
 		r.Message = err.Error()
-		if err.Error() == "transaction not found" || err.Error() == "ledger not found" {
+		if err.Error() == "transaction not found" {
 			c.JSON(http.StatusNotFound, r)
 			return
-		} else if err.Error() == "user is not a member of the ledger" {
+		} else if strings.Contains(err.Error(), "is not a member of the ledger") ||
+			err.Error() == "category does not exist in the ledger" {
 			c.JSON(http.StatusBadRequest, r)
 			return
 		}
@@ -253,3 +298,22 @@ func Update(c *gin.Context) {
 	r.Status = true
 	c.JSON(http.StatusOK, r)
 }
+
+// List the transactions referencing categories which do not exist in the ledger
+func GetInconsistent(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Get transactions
+	tss, err := getInconsistent(c.Param("ulid"), ledger.ContextCategories(c))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Return response
+	r.Status = true
+	r.Data = tss
+	c.JSON(http.StatusOK, r)
+}
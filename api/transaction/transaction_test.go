@@ -0,0 +1,233 @@
+package transaction
+
+import (
+	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/internal/validator"
+	"Fortune_Tracker_API/pkg/mongodb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const (
+	ledgerA = "0a0a0a0a-0000-4000-8000-00000000000a"
+	ledgerB = "0b0b0b0b-0000-4000-8000-00000000000b"
+	// Transaction stored in ledger B
+	transactionOfB = "0c0c0c0c-0000-4000-8000-00000000000c"
+)
+
+// Ledger A, whose members are alice and a deleted account
+var ledgerADoc = bson.D{
+	{Key: "ULID", Value: ledgerA},
+	{Key: "Name", Value: "Ledger A"},
+	{Key: "Currency", Value: "TWD"},
+	{Key: "Members", Value: bson.A{
+		bson.D{{Key: "UUID", Value: "alice"}, {Key: "Nickname", Value: "Alice"}, {Key: "Role", Value: "owner"}},
+		bson.D{{Key: "UUID", Value: "tombstone"}, {Key: "Nickname", Value: "Deleted user"}, {Key: "Role", Value: "editor"}, {Key: "Deleted", Value: true}},
+	}},
+	{Key: "Types", Value: bson.D{{Key: "ParentTypes", Value: bson.A{bson.D{
+		{Key: "PTID", Value: 1},
+		{Key: "Name", Value: "Food"},
+		{Key: "ChildTypes", Value: bson.A{bson.D{{Key: "CTID", Value: 1}, {Key: "Name", Value: "Lunch"}}}},
+	}}}}},
+}
+
+// The transaction of ledger B, as stored
+var transactionOfBDoc = bson.M{
+	"UTID":       transactionOfB,
+	"ULID":       ledgerB,
+	"Amount":     100,
+	"RecordTime": 1700000000,
+	"UpdateTime": 1700000000,
+	"Type":       bson.M{"Action": "expense", "ParentType": 1, "ChildType": 1},
+	"Name":       "Dinner",
+	"Payer":      "bob",
+	"Sharers":    bson.A{bson.M{"UUID": "bob", "Amount": 100}},
+}
+
+// Router with the middlewares of the /ledger/:ulid group, the caller is alice
+func newLedgerRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("UUID", "alice") })
+
+	ledgerRoutes := router.Group("/ledger/:ulid")
+	ledgerRoutes.Use(validator.ValidateULIDParam, ledger.CheckMembership, ledger.CheckPermission)
+	ledgerRoutes.POST("/transaction", Create)
+	ledgerRoutes.POST("/settlement", CreateSettlement)
+	ledgerRoutes.GET("/transaction/:utid", Get)
+	ledgerRoutes.PUT("/transaction/:utid", Update)
+	ledgerRoutes.DELETE("/transaction/:utid", Delete)
+	return router
+}
+
+// Filter of the command sent to the Transaction collection
+func commandFilter(e *event.CommandStartedEvent) bson.Raw {
+	switch e.CommandName {
+	case "find":
+		return e.Command.Lookup("filter").Document()
+	case "update":
+		return e.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+	case "delete":
+		return e.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+	}
+	return nil
+}
+
+// Whether every field of an equality filter has the value of the document
+func filterMatches(filter bson.Raw, doc bson.M) bool {
+	elems, _ := filter.Elements()
+	for _, elem := range elems {
+		if s, ok := elem.Value().StringValueOK(); !ok || doc[elem.Key()] != s {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTransactionOfAnotherLedgerIsNotFound(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	update := `{"Amount": 1, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "expense", "ParentType": 1, "ChildType": 1},
+		"Name": "Changed", "Payer": "alice", "Sharers": [{"UUID": "alice", "Amount": 1}]}`
+	nothingMatched := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0})
+
+	tests := []struct {
+		method   string
+		body     string
+		command  string
+		response bson.D
+	}{
+		{http.MethodGet, "", "find", mtest.CreateCursorResponse(0, "Fortune_Tracker.Transaction", mtest.FirstBatch)},
+		{http.MethodPut, update, "update", nothingMatched},
+		{http.MethodDelete, "", "delete", nothingMatched},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.method, func(mt *mtest.T) {
+			mongodb.LedgerCollection = mt.Client.Database("Fortune_Tracker").Collection("Ledger")
+			mongodb.TransactionCollection = mt.Client.Database("Fortune_Tracker").Collection("Transaction")
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "Fortune_Tracker.Ledger", mtest.FirstBatch, ledgerADoc), tt.response)
+
+			// Alice uses the UTID of ledger B under the path of her ledger A
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/ledger/"+ledgerA+"/transaction/"+transactionOfB, strings.NewReader(tt.body))
+			newLedgerRouter().ServeHTTP(w, req)
+
+			if w.Code != http.StatusNotFound {
+				mt.Fatalf("status %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+			}
+
+			// One command reached the transactions, scoped to ledger A so it can not select or change B's document
+			var commands []*event.CommandStartedEvent
+			for _, e := range mt.GetAllStartedEvents() {
+				if coll, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok && coll == "Transaction" {
+					commands = append(commands, e)
+				}
+			}
+			if len(commands) != 1 || commands[0].CommandName != tt.command {
+				mt.Fatalf("got %d commands on Transaction, want a single %s", len(commands), tt.command)
+			}
+			filter := commandFilter(commands[0])
+			if ulid, _ := filter.Lookup("ULID").StringValueOK(); ulid != ledgerA {
+				mt.Fatalf("filter %s is not scoped to ledger A", filter)
+			}
+			if utid, _ := filter.Lookup("UTID").StringValueOK(); utid != transactionOfB {
+				mt.Fatalf("filter %s is not for the requested UTID", filter)
+			}
+			if filterMatches(filter, transactionOfBDoc) {
+				mt.Fatalf("filter %s matches the transaction of ledger B", filter)
+			}
+		})
+	}
+}
+
+func TestDeletedMemberOnlyInSettlements(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"expense charged to a deleted account", "/transaction",
+			`{"Amount": 10, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "expense", "ParentType": 1, "ChildType": 1},
+			"Name": "Lunch", "Payer": "alice", "Sharers": [{"UUID": "alice", "Amount": 5}, {"UUID": "tombstone", "Amount": 5}]}`,
+			http.StatusBadRequest},
+		{"expense paid by a deleted account", "/transaction",
+			`{"Amount": 10, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "expense", "ParentType": 1, "ChildType": 1},
+			"Name": "Lunch", "Payer": "tombstone", "Sharers": [{"UUID": "alice", "Amount": 10}]}`,
+			http.StatusBadRequest},
+		{"settlement paid back to a deleted account", "/settlement", `{"From": "alice", "To": "tombstone", "Amount": 5}`, http.StatusCreated},
+		{"settlement paid back by a deleted account", "/transaction",
+			`{"Amount": 5, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "settlement"},
+			"Name": "Settlement", "Payer": "tombstone", "Sharers": [{"UUID": "alice", "Amount": 5}]}`,
+			http.StatusCreated},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mongodb.LedgerCollection = mt.Client.Database("Fortune_Tracker").Collection("Ledger")
+			mongodb.TransactionCollection = mt.Client.Database("Fortune_Tracker").Collection("Transaction")
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "Fortune_Tracker.Ledger", mtest.FirstBatch, ledgerADoc), mtest.CreateSuccessResponse())
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/ledger/"+ledgerA+tt.path, strings.NewReader(tt.body))
+			newLedgerRouter().ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				mt.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateNamingNonMembersIsRejected(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"expense paid by a non-member",
+			`{"Amount": 10, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "expense", "ParentType": 1, "ChildType": 1},
+			"Name": "Lunch", "Payer": "mallory", "Sharers": [{"UUID": "alice", "Amount": 10}]}`},
+		{"expense shared with a non-member",
+			`{"Amount": 10, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "expense", "ParentType": 1, "ChildType": 1},
+			"Name": "Lunch", "Payer": "alice", "Sharers": [{"UUID": "alice", "Amount": 5}, {"UUID": "mallory", "Amount": 5}]}`},
+		{"settlement paid back to a non-member",
+			`{"Amount": 5, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "settlement"},
+			"Name": "Settlement", "Payer": "alice", "Sharers": [{"UUID": "mallory", "Amount": 5}]}`},
+		{"expense charged to a deleted account",
+			`{"Amount": 10, "RecordTime": 1700000000, "UpdateTime": 1700000000, "Type": {"Action": "expense", "ParentType": 1, "ChildType": 1},
+			"Name": "Lunch", "Payer": "alice", "Sharers": [{"UUID": "tombstone", "Amount": 10}]}`},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mongodb.LedgerCollection = mt.Client.Database("Fortune_Tracker").Collection("Ledger")
+			mongodb.TransactionCollection = mt.Client.Database("Fortune_Tracker").Collection("Transaction")
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "Fortune_Tracker.Ledger", mtest.FirstBatch, ledgerADoc))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/ledger/"+ledgerA+"/transaction/"+transactionOfB, strings.NewReader(tt.body))
+			newLedgerRouter().ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "is not a member of the ledger") {
+				mt.Fatalf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			for _, e := range mt.GetAllStartedEvents() {
+				if coll, _ := e.Command.Lookup(e.CommandName).StringValueOK(); coll == "Transaction" {
+					mt.Fatalf("transaction updated: %s", e.Command)
+				}
+			}
+		})
+	}
+}
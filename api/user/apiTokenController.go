@@ -0,0 +1,103 @@
+package user
+
+import (
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createAPITokenRequest struct {
+	Name   string   `json:"Name" binding:"required"`
+	Scopes []string `json:"Scopes" binding:"required"`
+	// Tokens without expiry live until revoked
+	ExpiresInDays *int `json:"ExpiresInDays"`
+}
+
+func CreateAPIToken(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var createAPITokenRequest createAPITokenRequest
+	if err = c.ShouldBindJSON(&createAPITokenRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check pass in fields
+	if err = auth.ValidateScopes(createAPITokenRequest.Scopes); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+	var expiresAt *int64
+	if days := createAPITokenRequest.ExpiresInDays; days != nil {
+		if *days <= 0 {
+			r.Message = "ExpiresInDays should be positive"
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		t := time.Now().AddDate(0, 0, *days).Unix()
+		expiresAt = &t
+	}
+
+	// Create the token
+	apiToken, err := auth.CreateAPIToken(c.MustGet("UUID").(string), createAPITokenRequest.Name, createAPITokenRequest.Scopes, expiresAt)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return token with formatted response
+	r.Status = true
+	r.Data = apiToken
+	c.JSON(http.StatusCreated, r)
+}
+
+func ListAPITokens(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Get the tokens
+	apiTokens, err := auth.ListAPITokens(c.MustGet("UUID").(string))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return tokens with formatted response
+	r.Status = true
+	r.Data = apiTokens
+	c.JSON(http.StatusOK, r)
+}
+
+func RevokeAPIToken(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Revoke the token
+	if err := auth.RevokeAPIToken(c.MustGet("UUID").(string), c.Param("atid")); err != nil {
+		r.Message = err.Error()
+		if r.Message == "API token not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,186 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mailer"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"database/sql"
+	"errors"
+	"net/mail"
+	"time"
+)
+
+// Lifetime of email verification tokens, EMAIL_VERIFICATION_TTL in config (default 24 hours)
+func emailVerificationTTL() time.Duration {
+	if ttl := config.Viper.GetDuration("EMAIL_VERIFICATION_TTL"); ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}
+
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// Check no other user already owns the email
+func emailTaken(email, UUID string) (bool, error) {
+	var owner string
+
+	query := "SELECT UUID FROM User WHERE Email = ?"
+	err := mariadb.DB.QueryRow(query, email).Scan(&owner)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		logger.Error("[USER] " + err.Error())
+		return false, err
+	}
+
+	return owner != UUID, nil
+}
+
+// Send a verification token for email, the email becomes the user's email once verified
+func sendEmailVerification(UUID, email string) error {
+	var query string
+	var err error
+
+	// Invalidate verification tokens issued before
+	query = "UPDATE Email_Verification SET Used_At = ? WHERE UUID = ? AND Used_At IS NULL"
+	if _, err = mariadb.DB.Exec(query, time.Now().Unix(), UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Generate verification token and store its hash
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	query = "INSERT INTO Email_Verification (Token_Hash, UUID, Email, Expires_At) VALUES (?, ?, ?, ?)"
+	_, err = mariadb.DB.Exec(query, hash, UUID, email, time.Now().Add(emailVerificationTTL()).Unix())
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Send the token to the address being verified
+	body := "Use the following token to verify your email address, it expires in " + emailVerificationTTL().String() + ":\n\n" + token
+	if url := config.Viper.GetString("EMAIL_VERIFICATION_URL"); url != "" {
+		body += "\n\nOr open: " + url + "?token=" + token
+	}
+	if err = mailer.Send(email, "Verify your Fortune Tracker email", body); err != nil {
+		return err
+	}
+
+	logger.Info("[USER] Sent email verification for UUID: " + UUID)
+
+	return nil
+}
+
+// Resend the verification of a pending email change, or of the current email if it is not verified
+func resendEmailVerification(UUID string) error {
+	var query, email, pending string
+	var verified bool
+
+	query = "SELECT Email, Email_Verified FROM User WHERE UUID = ?"
+	err := mariadb.DB.QueryRow(query, UUID).Scan(&email, &verified)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] UUID: " + UUID + " not found")
+			return errors.New("user not found")
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// A new email from update is still waiting for its verification
+	query = "SELECT Email FROM Email_Verification WHERE UUID = ? AND Email != ? AND Used_At IS NULL ORDER BY Expires_At DESC LIMIT 1"
+	err = mariadb.DB.QueryRow(query, UUID, email).Scan(&pending)
+	if err == nil {
+		return sendEmailVerification(UUID, pending)
+	} else if err != sql.ErrNoRows {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	if verified {
+		logger.Warn("[USER] Email already verified for UUID: " + UUID)
+		return errors.New("email already verified")
+	}
+
+	return sendEmailVerification(UUID, email)
+}
+
+func verifyEmail(ver verifyEmailRequest) error {
+	var query, UUID, email string
+	var expiresAt int64
+	var usedAt sql.NullInt64
+	var err error
+	hash := auth.HashToken(ver.Token)
+
+	// Find the verification token
+	query = "SELECT UUID, Email, Expires_At, Used_At FROM Email_Verification WHERE Token_Hash = ?"
+	err = mariadb.DB.QueryRow(query, hash).Scan(&UUID, &email, &expiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] Email verification token not found")
+			return errors.New("invalid or expired verification token")
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	if usedAt.Valid || expiresAt < time.Now().Unix() {
+		logger.Warn("[USER] Email verification token used or expired for UUID: " + UUID)
+		return errors.New("invalid or expired verification token")
+	}
+
+	// The address may have been registered by someone else in the meantime
+	if taken, err := emailTaken(email, UUID); err != nil {
+		return err
+	} else if taken {
+		logger.Warn("[USER] Email:" + email + " already exists")
+		return errors.New("email already exists")
+	}
+
+	// Consume the token and apply the verified email together
+	tx, err := mariadb.DB.Begin()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	query = "UPDATE Email_Verification SET Used_At = ? WHERE Token_Hash = ? AND Used_At IS NULL"
+	result, err := tx.Exec(query, time.Now().Unix(), hash)
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	if rowsaffected, err := result.RowsAffected(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	} else if rowsaffected == 0 {
+		logger.Warn("[USER] Email verification token used concurrently for UUID: " + UUID)
+		return errors.New("invalid or expired verification token")
+	}
+
+	query = "UPDATE User SET Email = ?, Email_Verified = TRUE WHERE UUID = ?"
+	if _, err = tx.Exec(query, email, UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	logger.Info("[USER] Successfully verified email for UUID: " + UUID)
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type verifyEmailRequest struct {
+	Token string `json:"Token" binding:"required"`
+}
+
+func VerifyEmail(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var verifyEmailRequest verifyEmailRequest
+	if err = c.ShouldBindJSON(&verifyEmailRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Verify the email
+	if err = verifyEmail(verifyEmailRequest); err != nil {
+		r.Message = err.Error()
+		if r.Message == "invalid or expired verification token" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		} else if r.Message == "email already exists" {
+			c.JSON(http.StatusConflict, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func ResendEmailVerification(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Send a new verification token to the current email
+	if err := resendEmailVerification(c.MustGet("UUID").(string)); err != nil {
+		r.Message = err.Error()
+		if r.Message == "user not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "email already verified" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
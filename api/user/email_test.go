@@ -0,0 +1,78 @@
+package user
+
+import (
+	"Fortune_Tracker_API/pkg/mailer"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectResendLookups(mock sqlmock.Sqlmock, verified bool, pending *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Email, Email_Verified FROM User WHERE UUID = ?")).
+		WithArgs("user-uuid").
+		WillReturnRows(sqlmock.NewRows([]string{"Email", "Email_Verified"}).AddRow("old@example.com", verified))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Email FROM Email_Verification WHERE UUID = ? AND Email != ? AND Used_At IS NULL")).
+		WithArgs("user-uuid", "old@example.com").
+		WillReturnRows(pending)
+}
+
+func expectVerificationSent(mock sqlmock.Sqlmock, email string) {
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Email_Verification SET Used_At = ? WHERE UUID = ? AND Used_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO Email_Verification (Token_Hash, UUID, Email, Expires_At) VALUES (?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid", email, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestResendEmailVerificationOfPendingChange(t *testing.T) {
+	mock := setupMockDB(t)
+	mailPath := filepath.Join(t.TempDir(), "mail.txt")
+	mailer.Mail = &mailer.FileSender{Path: mailPath}
+
+	// The current email is verified, the new one from update is not yet
+	expectResendLookups(mock, true, sqlmock.NewRows([]string{"Email"}).AddRow("new@example.com"))
+	expectVerificationSent(mock, "new@example.com")
+
+	if err := resendEmailVerification("user-uuid"); err != nil {
+		t.Fatalf("resendEmailVerification: %v", err)
+	}
+	if mail, err := os.ReadFile(mailPath); err != nil || !strings.Contains(string(mail), "new@example.com") {
+		t.Fatalf("verification not mailed to the new email: %s, %v", mail, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResendEmailVerificationOfCurrentEmail(t *testing.T) {
+	mock := setupMockDB(t)
+	mailer.Mail = &mailer.FileSender{Path: filepath.Join(t.TempDir(), "mail.txt")}
+
+	expectResendLookups(mock, false, sqlmock.NewRows([]string{"Email"}))
+	expectVerificationSent(mock, "old@example.com")
+
+	if err := resendEmailVerification("user-uuid"); err != nil {
+		t.Fatalf("resendEmailVerification: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResendEmailVerificationAlreadyVerified(t *testing.T) {
+	mock := setupMockDB(t)
+
+	expectResendLookups(mock, true, sqlmock.NewRows([]string{"Email"}))
+
+	if err := resendEmailVerification("user-uuid"); err == nil || err.Error() != "email already verified" {
+		t.Fatalf("got %v, want email already verified", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
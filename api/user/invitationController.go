@@ -0,0 +1,130 @@
+package user
+
+import (
+	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type acceptInvitationRequest struct {
+	Nickname string `json:"Nickname" binding:"required"`
+}
+
+// Verified email of the user, invitations sent to unverified emails can not be used
+func verifiedEmail(UUID string) (string, error) {
+	userInfo, err := get(UUID)
+	if err != nil {
+		return "", err
+	} else if !userInfo.Email_Verified {
+		return "", nil
+	}
+	return userInfo.Email, nil
+}
+
+func GetInvitations(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Get the email invitations sent to the user
+	email, err := verifiedEmail(c.MustGet("UUID").(string))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+	invitations := []ledger.Invitation{}
+	if email != "" {
+		if invitations, err = ledger.GetPendingInvitations(email); err != nil {
+			r.Message = err.Error()
+			c.JSON(http.StatusInternalServerError, r)
+			return
+		}
+	}
+
+	// return invitations with formatted response
+	r.Status = true
+	r.Data = invitations
+	c.JSON(http.StatusOK, r)
+}
+
+func AcceptInvitation(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var acceptInvitationRequest acceptInvitationRequest
+	if err = c.ShouldBindJSON(&acceptInvitationRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	UUID := c.MustGet("UUID").(string)
+	email, err := verifiedEmail(UUID)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Join the ledger
+	ULID, err := ledger.AcceptInvitation(c.Param("code"), UUID, email, acceptInvitationRequest.Nickname)
+	if err != nil {
+		r.Message = err.Error()
+		if r.Message == "invitation not found" || r.Message == "ledger not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "invitation is no longer pending" ||
+			r.Message == "invitation is expired" ||
+			r.Message == "user already exists in the ledger" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return ULID with formatted response
+	r.Status = true
+	r.Data = response.ULIDResponse{ULID: ULID}
+	c.JSON(http.StatusOK, r)
+}
+
+func DeclineInvitation(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	UUID := c.MustGet("UUID").(string)
+	email, err := verifiedEmail(UUID)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Decline the invitation
+	if err = ledger.DeclineInvitation(c.Param("code"), UUID, email); err != nil {
+		r.Message = err.Error()
+		if r.Message == "invitation not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "invitation is no longer pending" ||
+			r.Message == "invitation is expired" ||
+			r.Message == "only email invitations can be declined" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,143 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Failed logins are tracked per account (email) and per client IP
+const (
+	throttleAccount = "account"
+	throttleIP      = "ip"
+)
+
+const (
+	// Failures older than this no longer count
+	failureWindow = 24 * time.Hour
+	// Lockouts start at baseLockout and double with every further failure
+	baseLockout = time.Minute
+	maxLockout  = time.Hour
+)
+
+// Failures before a lockout, LOGIN_MAX_ACCOUNT_FAILURES / LOGIN_MAX_IP_FAILURES in config
+func maxFailures(kind string) int {
+	if kind == throttleIP {
+		if max := config.Viper.GetInt("LOGIN_MAX_IP_FAILURES"); max > 0 {
+			return max
+		}
+		return 20
+	}
+	if max := config.Viper.GetInt("LOGIN_MAX_ACCOUNT_FAILURES"); max > 0 {
+		return max
+	}
+	return 5
+}
+
+// Lockout duration after the given number of failures
+func lockoutDuration(kind string, failures int) time.Duration {
+	over := failures - maxFailures(kind)
+	if over < 0 {
+		return 0
+	}
+	d := baseLockout
+	for i := 0; i < over && d < maxLockout; i++ {
+		d *= 2
+	}
+	if d > maxLockout {
+		d = maxLockout
+	}
+	return d
+}
+
+// Kind and identifier of every counter a login attempt is tracked by
+func throttleKeys(email, IP string) [][2]string {
+	return [][2]string{{throttleAccount, strings.ToLower(email)}, {throttleIP, IP}}
+}
+
+// Check whether logins for the email or from the IP are locked, returns the remaining lock time
+func loginLockedFor(email, IP string) (time.Duration, error) {
+	var remaining time.Duration
+	now := time.Now().Unix()
+
+	for _, key := range throttleKeys(email, IP) {
+		kind, identifier := key[0], key[1]
+		var lockedUntil int64
+		query := "SELECT Locked_Until FROM Login_Failure WHERE Kind = ? AND Identifier = ?"
+		err := mariadb.DB.QueryRow(query, kind, identifier).Scan(&lockedUntil)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			logger.Error("[USER] " + err.Error())
+			return 0, err
+		}
+		if lockedUntil > now && time.Duration(lockedUntil-now)*time.Second > remaining {
+			remaining = time.Duration(lockedUntil-now) * time.Second
+		}
+	}
+
+	return remaining, nil
+}
+
+// Record a failed login for the email and the IP, locking them out once over the limit
+func recordLoginFailure(email, IP string) error {
+	now := time.Now()
+
+	for _, key := range throttleKeys(email, IP) {
+		kind, identifier := key[0], key[1]
+		var failures int
+
+		// Increase the failure count, restarting it when the last failure is outside the window
+		query := `INSERT INTO Login_Failure (Kind, Identifier, Failures, Last_Failure_At, Locked_Until) VALUES (?, ?, 1, ?, 0)
+			ON DUPLICATE KEY UPDATE Failures = IF(Last_Failure_At < ?, 1, Failures + 1), Last_Failure_At = VALUES(Last_Failure_At)`
+		_, err := mariadb.DB.Exec(query, kind, identifier, now.Unix(), now.Add(-failureWindow).Unix())
+		if err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+
+		query = "SELECT Failures FROM Login_Failure WHERE Kind = ? AND Identifier = ?"
+		if err = mariadb.DB.QueryRow(query, kind, identifier).Scan(&failures); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+
+		lockout := lockoutDuration(kind, failures)
+		if lockout == 0 {
+			continue
+		}
+
+		// Lock out and keep an audit record
+		lockedUntil := now.Add(lockout).Unix()
+		query = "UPDATE Login_Failure SET Locked_Until = ? WHERE Kind = ? AND Identifier = ?"
+		if _, err = mariadb.DB.Exec(query, lockedUntil, kind, identifier); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+
+		query = "INSERT INTO Login_Lockout (Kind, Identifier, IP, Failures, Locked_Until, Created_At) VALUES (?, ?, ?, ?, ?, ?)"
+		if _, err = mariadb.DB.Exec(query, kind, identifier, IP, failures, lockedUntil, now.Unix()); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+
+		logger.Warn(fmt.Sprintf("[USER] Locked out %s: %s for %s after %d failed logins (IP: %s)", kind, identifier, lockout, failures, IP))
+	}
+
+	return nil
+}
+
+// Forget the failed logins of the account after a successful login
+func clearLoginFailures(email string) error {
+	query := "DELETE FROM Login_Failure WHERE Kind = ? AND Identifier = ?"
+	if _, err := mariadb.DB.Exec(query, throttleAccount, strings.ToLower(email)); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	return nil
+}
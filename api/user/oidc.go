@@ -0,0 +1,185 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/oidc"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDC logins have to be completed within this time
+const oidcStateTTL = 10 * time.Minute
+
+// Start an OIDC login, returns the provider's login URL and the state to send back with the code
+func startOIDCLogin(provider *oidc.Provider) (string, string, error) {
+	state, stateHash, err := auth.NewOpaqueToken()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+	nonce, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+	verifier, _, err := auth.NewOpaqueToken()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	// The nonce and PKCE verifier stay on the server
+	query := "INSERT INTO OIDC_State (State_Hash, Provider, Nonce, Code_Verifier, Expires_At) VALUES (?, ?, ?, ?, ?)"
+	_, err = mariadb.DB.Exec(query, stateHash, provider.Name, nonce, verifier, time.Now().Add(oidcStateTTL).Unix())
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+// Complete an OIDC login, returns the UUID and email of the linked (or newly created) user
+func finishOIDCLogin(provider *oidc.Provider, ocr oidcCallbackRequest) (string, string, error) {
+	var query, nonce, verifier, providerName string
+	var expiresAt int64
+	var err error
+	stateHash := auth.HashToken(ocr.State)
+
+	// Consume the state
+	query = "SELECT Provider, Nonce, Code_Verifier, Expires_At FROM OIDC_State WHERE State_Hash = ?"
+	err = mariadb.DB.QueryRow(query, stateHash).Scan(&providerName, &nonce, &verifier, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] OIDC state not found")
+			return "", "", errors.New("invalid or expired login state")
+		}
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+	result, err := mariadb.DB.Exec("DELETE FROM OIDC_State WHERE State_Hash = ?", stateHash)
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+	if rowsaffected, _ := result.RowsAffected(); rowsaffected == 0 || providerName != provider.Name || expiresAt < time.Now().Unix() {
+		logger.Warn("[USER] OIDC state used, expired or issued for another provider")
+		return "", "", errors.New("invalid or expired login state")
+	}
+
+	// Exchange the code for a verified identity
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	identity, err := provider.Exchange(ctx, ocr.Code, verifier, nonce)
+	if err != nil {
+		logger.Warn("[USER] OIDC login with " + provider.Name + " failed: " + err.Error())
+		return "", "", errors.New("identity provider login failed")
+	}
+
+	return linkIdentity(provider.Name, identity)
+}
+
+// Find the user of an external identity, linking it to the user with the same verified email
+// or creating a new user the first time
+func linkIdentity(providerName string, identity oidc.Identity) (string, string, error) {
+	var query, UUID, email string
+	var verified bool
+	var err error
+	requireVerified := config.Viper.GetBool("REQUIRE_EMAIL_VERIFICATION")
+
+	// Unverified provider emails are kept from logging in like unverified accounts (REQUIRE_EMAIL_VERIFICATION)
+	if !identity.EmailVerified && requireVerified {
+		logger.Warn("[USER] Email not verified by " + providerName + " for subject: " + identity.Subject)
+		return "", "", errors.New("email not verified")
+	}
+
+	// Identity already linked
+	query = "SELECT u.UUID, u.Email, u.Email_Verified FROM User_Identity i JOIN User u ON u.UUID = i.UUID WHERE i.Provider = ? AND i.Subject = ?"
+	err = mariadb.DB.QueryRow(query, providerName, identity.Subject).Scan(&UUID, &email, &verified)
+	if err == nil {
+		if !verified && requireVerified {
+			logger.Warn("[USER] Email not verified for UUID: " + UUID)
+			return "", "", errors.New("email not verified")
+		}
+		logger.Info("[USER] OIDC login with " + providerName + " for UUID: " + UUID)
+		return UUID, email, nil
+	} else if err != sql.ErrNoRows {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	if identity.Email == "" {
+		logger.Warn("[USER] " + providerName + " returned no email for subject: " + identity.Subject)
+		return "", "", errors.New("identity provider did not return an email")
+	}
+
+	// Existing user with the same email, only linked when the provider verified the email
+	query = "SELECT UUID FROM User WHERE Email = ?"
+	err = mariadb.DB.QueryRow(query, identity.Email).Scan(&UUID)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	} else if err == nil && !identity.EmailVerified {
+		logger.Warn("[USER] Unverified " + providerName + " email matches existing user: " + identity.Email)
+		return "", "", errors.New("email already exists")
+	}
+
+	tx, err := mariadb.DB.Begin()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	if UUID == "" {
+		// New user without password, one can be set with the password reset flow
+		UUID = uuid.NewString()
+		username := identity.Name
+		if username == "" {
+			username = strings.Split(identity.Email, "@")[0]
+		}
+
+		query = "INSERT INTO User (UUID, Email, Password, Email_Verified) VALUES (?, ?, '', ?)"
+		if _, err = tx.Exec(query, UUID, identity.Email, identity.EmailVerified); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return "", "", err
+		}
+		query = "INSERT INTO User_Info (UUID, Username, Is_Pro) VALUES (?, ?, ?)"
+		if _, err = tx.Exec(query, UUID, username, false); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return "", "", err
+		}
+		logger.Info("[USER] Registered user with " + providerName + ": " + identity.Email)
+	}
+
+	query = "INSERT INTO User_Identity (Provider, Subject, UUID, Created_At) VALUES (?, ?, ?, ?)"
+	if _, err = tx.Exec(query, providerName, identity.Subject, UUID, time.Now().Unix()); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	logger.Info("[USER] Linked " + providerName + " identity to UUID: " + UUID)
+
+	return UUID, identity.Email, nil
+}
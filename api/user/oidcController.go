@@ -0,0 +1,91 @@
+package user
+
+import (
+	"Fortune_Tracker_API/internal/oidc"
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type oidcCallbackRequest struct {
+	Code       string `json:"Code" binding:"required"`
+	State      string `json:"State" binding:"required"`
+	DeviceName string `json:"DeviceName"`
+}
+
+func StartOIDCLogin(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Find the provider
+	provider, ok := oidc.Providers[c.Param("provider")]
+	if !ok {
+		logger.Warn("[USER] Unknown OIDC provider: " + c.Param("provider"))
+		r.Message = "identity provider not found"
+		c.JSON(http.StatusNotFound, r)
+		return
+	}
+
+	// Build the login URL
+	authURL, state, err := startOIDCLogin(provider)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return login URL with formatted response
+	r.Status = true
+	r.Data = response.OIDCLoginResponse{AuthURL: authURL, State: state}
+	c.JSON(http.StatusOK, r)
+}
+
+func FinishOIDCLogin(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Find the provider
+	provider, ok := oidc.Providers[c.Param("provider")]
+	if !ok {
+		logger.Warn("[USER] Unknown OIDC provider: " + c.Param("provider"))
+		r.Message = "identity provider not found"
+		c.JSON(http.StatusNotFound, r)
+		return
+	}
+
+	// Parse request body to JSON format
+	var oidcCallbackRequest oidcCallbackRequest
+	if err = c.ShouldBindJSON(&oidcCallbackRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Get the user of the identity
+	UUID, email, err := finishOIDCLogin(provider, oidcCallbackRequest)
+	if err != nil {
+		r.Message = err.Error()
+		if r.Message == "invalid or expired login state" ||
+			r.Message == "identity provider login failed" ||
+			r.Message == "identity provider did not return an email" {
+			c.JSON(http.StatusUnauthorized, r)
+			return
+		} else if r.Message == "email not verified" {
+			c.JSON(http.StatusForbidden, r)
+			return
+		} else if r.Message == "email already exists" {
+			c.JSON(http.StatusConflict, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Second factor if enabled, then tokens
+	completeLogin(c, r, UUID, email, oidcCallbackRequest.DeviceName)
+}
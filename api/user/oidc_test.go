@@ -0,0 +1,475 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/oidc"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	mockClientID    = "fortune-tracker"
+	mockRedirectURL = "https://app.example.com/oidc/callback"
+	mockKid         = "mock-key"
+)
+
+// An OpenID Connect issuer serving discovery, JWKS and a token endpoint that checks PKCE
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu             sync.Mutex
+	authorizations map[string]mockAuthorization
+	tokenRequests  int
+}
+
+type mockAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := &mockIssuer{key: key, authorizations: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 mi.URL,
+			"authorization_endpoint": mi.URL + "/authorize",
+			"token_endpoint":         mi.URL + "/token",
+			"jwks_uri":               mi.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": mockKid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", mi.token)
+
+	mi.Server = httptest.NewServer(mux)
+	t.Cleanup(mi.Close)
+	return mi
+}
+
+func (mi *mockIssuer) provider() *oidc.Provider {
+	return &oidc.Provider{
+		Name:        "mock",
+		Issuer:      mi.URL,
+		ClientID:    mockClientID,
+		RedirectURL: mockRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+	}
+}
+
+// Log in at the issuer through the URL returned by the API, returns the authorization code.
+// The ID token gets the nonce of the URL unless the claims set one.
+func (mi *mockIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme+"://"+u.Host+u.Path != mi.URL+"/authorize" {
+		t.Fatalf("login URL %s is not the authorization endpoint", authURL)
+	} else if q.Get("client_id") != mockClientID || q.Get("redirect_uri") != mockRedirectURL || q.Get("response_type") != "code" {
+		t.Fatalf("unexpected login URL parameters: %s", u.RawQuery)
+	} else if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("state") == "" {
+		t.Fatalf("login URL without PKCE or state: %s", u.RawQuery)
+	}
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = q.Get("nonce")
+	}
+	code, _, _ := auth.NewOpaqueToken()
+
+	mi.mu.Lock()
+	mi.authorizations[code] = mockAuthorization{challenge: q.Get("code_challenge"), claims: claims}
+	mi.mu.Unlock()
+	return code
+}
+
+func (mi *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	mi.mu.Lock()
+	mi.tokenRequests++
+	authorization, ok := mi.authorizations[r.PostFormValue("code")]
+	delete(mi.authorizations, r.PostFormValue("code"))
+	mi.mu.Unlock()
+
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("client_id") != mockClientID ||
+		r.PostFormValue("redirect_uri") != mockRedirectURL || !ok ||
+		oidc.CodeChallenge(r.PostFormValue("code_verifier")) != authorization.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss": mi.URL,
+		"aud": mockClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range authorization.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockKid
+	idToken, err := token.SignedString(mi.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+func (mi *mockIssuer) tokenRequestCount() int {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	return mi.tokenRequests
+}
+
+// A login started with the API, as stored in OIDC_State
+type oidcLogin struct {
+	state    string
+	nonce    string
+	verifier string
+	authURL  string
+}
+
+func startMockLogin(t *testing.T, mock sqlmock.Sqlmock, provider *oidc.Provider) oidcLogin {
+	t.Helper()
+
+	nonce, verifier := &captureArg{}, &captureArg{}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO OIDC_State (State_Hash, Provider, Nonce, Code_Verifier, Expires_At) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), provider.Name, nonce, verifier, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	authURL, state, err := startOIDCLogin(provider)
+	if err != nil {
+		t.Fatalf("startOIDCLogin: %v", err)
+	}
+	if got := mustQuery(t, authURL).Get("state"); got != state {
+		t.Fatalf("login URL state %s, want %s", got, state)
+	}
+	return oidcLogin{state: state, nonce: nonce.value.(string), verifier: verifier.value.(string), authURL: authURL}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+// Expect the state of the login to be found and consumed
+func expectStateConsumed(mock sqlmock.Sqlmock, login oidcLogin, provider string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Provider, Nonce, Code_Verifier, Expires_At FROM OIDC_State WHERE State_Hash = ?")).
+		WithArgs(auth.HashToken(login.state)).
+		WillReturnRows(sqlmock.NewRows([]string{"Provider", "Nonce", "Code_Verifier", "Expires_At"}).
+			AddRow(provider, login.nonce, login.verifier, time.Now().Add(oidcStateTTL).Unix()))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM OIDC_State WHERE State_Hash = ?")).
+		WithArgs(auth.HashToken(login.state)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectIdentityNotLinked(mock sqlmock.Sqlmock, subject string) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM User_Identity i JOIN User u ON u.UUID = i.UUID WHERE i.Provider = ? AND i.Subject = ?")).
+		WithArgs("mock", subject).
+		WillReturnRows(sqlmock.NewRows([]string{"UUID", "Email", "Email_Verified"}))
+}
+
+func identityClaims(subject, email string, verified bool) jwt.MapClaims {
+	return jwt.MapClaims{"sub": subject, "email": email, "email_verified": verified, "name": "Alice"}
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	mock := setupMockDB(t)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	login := startMockLogin(t, mock, provider)
+	if challenge := mustQuery(t, login.authURL).Get("code_challenge"); challenge != oidc.CodeChallenge(login.verifier) {
+		t.Fatalf("code challenge %s does not match the stored verifier", challenge)
+	}
+	code := issuer.authorize(t, login.authURL, identityClaims("subject-1", "alice@example.com", true))
+
+	expectStateConsumed(mock, login, "mock")
+	expectIdentityNotLinked(mock, "subject-1")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID FROM User WHERE Email = ?")).
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"UUID"}))
+	newUUID := &captureArg{}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO User (UUID, Email, Password, Email_Verified) VALUES (?, ?, '', ?)")).
+		WithArgs(newUUID, "alice@example.com", true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO User_Info (UUID, Username, Is_Pro) VALUES (?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "Alice", false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO User_Identity (Provider, Subject, UUID, Created_At) VALUES (?, ?, ?, ?)")).
+		WithArgs("mock", "subject-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	UUID, email, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state})
+	if err != nil {
+		t.Fatalf("finishOIDCLogin: %v", err)
+	}
+	if UUID == "" || UUID != newUUID.value || email != "alice@example.com" {
+		t.Fatalf("got %s %s, want the new user %v with alice@example.com", UUID, email, newUUID.value)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginLinksExistingUser(t *testing.T) {
+	mock := setupMockDB(t)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	login := startMockLogin(t, mock, provider)
+	code := issuer.authorize(t, login.authURL, identityClaims("subject-2", "bob@example.com", true))
+
+	// Only the identity is added to the user with the same email
+	expectStateConsumed(mock, login, "mock")
+	expectIdentityNotLinked(mock, "subject-2")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID FROM User WHERE Email = ?")).
+		WithArgs("bob@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"UUID"}).AddRow("bob-uuid"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO User_Identity (Provider, Subject, UUID, Created_At) VALUES (?, ?, ?, ?)")).
+		WithArgs("mock", "subject-2", "bob-uuid", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	UUID, email, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state})
+	if err != nil {
+		t.Fatalf("finishOIDCLogin: %v", err)
+	}
+	if UUID != "bob-uuid" || email != "bob@example.com" {
+		t.Fatalf("got %s %s, want bob-uuid bob@example.com", UUID, email)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginAlreadyLinked(t *testing.T) {
+	mock := setupMockDB(t)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	login := startMockLogin(t, mock, provider)
+	code := issuer.authorize(t, login.authURL, identityClaims("subject-3", "carol@provider.example", true))
+
+	// The linked user is returned with its own email, nothing is written
+	expectStateConsumed(mock, login, "mock")
+	mock.ExpectQuery(regexp.QuoteMeta("FROM User_Identity i JOIN User u ON u.UUID = i.UUID WHERE i.Provider = ? AND i.Subject = ?")).
+		WithArgs("mock", "subject-3").
+		WillReturnRows(sqlmock.NewRows([]string{"UUID", "Email", "Email_Verified"}).AddRow("carol-uuid", "carol@example.com", true))
+
+	UUID, email, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state})
+	if err != nil {
+		t.Fatalf("finishOIDCLogin: %v", err)
+	}
+	if UUID != "carol-uuid" || email != "carol@example.com" {
+		t.Fatalf("got %s %s, want carol-uuid carol@example.com", UUID, email)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginUnverifiedEmailOfExistingUser(t *testing.T) {
+	mock := setupMockDB(t)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	login := startMockLogin(t, mock, provider)
+	code := issuer.authorize(t, login.authURL, identityClaims("subject-4", "bob@example.com", false))
+
+	// An unverified provider email is not enough to take over the account
+	expectStateConsumed(mock, login, "mock")
+	expectIdentityNotLinked(mock, "subject-4")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID FROM User WHERE Email = ?")).
+		WithArgs("bob@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"UUID"}).AddRow("bob-uuid"))
+
+	if _, _, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state}); err == nil || err.Error() != "email already exists" {
+		t.Fatalf("got %v, want email already exists", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginNonceMismatch(t *testing.T) {
+	mock := setupMockDB(t)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	login := startMockLogin(t, mock, provider)
+	claims := identityClaims("subject-5", "dave@example.com", true)
+	claims["nonce"] = "nonce-of-another-login"
+	code := issuer.authorize(t, login.authURL, claims)
+
+	// The ID token is rejected before any user is looked up
+	expectStateConsumed(mock, login, "mock")
+
+	if _, _, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state}); err == nil || err.Error() != "identity provider login failed" {
+		t.Fatalf("got %v, want identity provider login failed", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginCodeVerifierMismatch(t *testing.T) {
+	mock := setupMockDB(t)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	// The code was issued for another login, its PKCE challenge does not match our verifier
+	other := startMockLogin(t, mock, provider)
+	login := startMockLogin(t, mock, provider)
+	code := issuer.authorize(t, other.authURL, identityClaims("subject-6", "erin@example.com", true))
+	expectStateConsumed(mock, login, "mock")
+
+	if _, _, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state}); err == nil || err.Error() != "identity provider login failed" {
+		t.Fatalf("got %v, want identity provider login failed", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginStateMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock, login oidcLogin)
+	}{
+		{"unknown state", func(mock sqlmock.Sqlmock, login oidcLogin) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT Provider, Nonce, Code_Verifier, Expires_At FROM OIDC_State WHERE State_Hash = ?")).
+				WillReturnRows(sqlmock.NewRows([]string{"Provider", "Nonce", "Code_Verifier", "Expires_At"}))
+		}},
+		{"state of another provider", func(mock sqlmock.Sqlmock, login oidcLogin) {
+			expectStateConsumed(mock, login, "other")
+		}},
+		{"state already used", func(mock sqlmock.Sqlmock, login oidcLogin) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT Provider, Nonce, Code_Verifier, Expires_At FROM OIDC_State WHERE State_Hash = ?")).
+				WillReturnRows(sqlmock.NewRows([]string{"Provider", "Nonce", "Code_Verifier", "Expires_At"}).
+					AddRow("mock", login.nonce, login.verifier, time.Now().Add(oidcStateTTL).Unix()))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM OIDC_State WHERE State_Hash = ?")).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}},
+		{"expired state", func(mock sqlmock.Sqlmock, login oidcLogin) {
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT Provider, Nonce, Code_Verifier, Expires_At FROM OIDC_State WHERE State_Hash = ?")).
+				WillReturnRows(sqlmock.NewRows([]string{"Provider", "Nonce", "Code_Verifier", "Expires_At"}).
+					AddRow("mock", login.nonce, login.verifier, time.Now().Add(-time.Minute).Unix()))
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM OIDC_State WHERE State_Hash = ?")).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			login := startMockLogin(t, mock, provider)
+			code := issuer.authorize(t, login.authURL, identityClaims("subject-7", "frank@example.com", true))
+			tt.expect(mock, login)
+
+			// The code is never exchanged
+			before := issuer.tokenRequestCount()
+			if _, _, err := finishOIDCLogin(provider, oidcCallbackRequest{Code: code, State: login.state}); err == nil || err.Error() != "invalid or expired login state" {
+				t.Fatalf("got %v, want invalid or expired login state", err)
+			}
+			if issuer.tokenRequestCount() != before {
+				t.Fatal("the code was exchanged with an invalid state")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRequiresVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	oidc.Providers = map[string]*oidc.Provider{"mock": provider}
+
+	tests := []struct {
+		name     string
+		verified bool
+		expect   func(mock sqlmock.Sqlmock)
+	}{
+		// Refused before any user is looked up or created
+		{"unverified provider email", false, func(mock sqlmock.Sqlmock) {}},
+		{"linked account not verified", true, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(regexp.QuoteMeta("FROM User_Identity i JOIN User u ON u.UUID = i.UUID WHERE i.Provider = ? AND i.Subject = ?")).
+				WillReturnRows(sqlmock.NewRows([]string{"UUID", "Email", "Email_Verified"}).AddRow("grace-uuid", "grace@example.com", false))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := setupMockDB(t)
+			config.Viper.Set("REQUIRE_EMAIL_VERIFICATION", true)
+
+			login := startMockLogin(t, mock, provider)
+			code := issuer.authorize(t, login.authURL, identityClaims("subject-8", "grace@example.com", tt.verified))
+			expectStateConsumed(mock, login, "mock")
+			tt.expect(mock)
+
+			router := gin.New()
+			router.POST("/user/oidc/:provider/callback", FinishOIDCLogin)
+			body, _ := json.Marshal(oidcCallbackRequest{Code: code, State: login.state})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/user/oidc/mock/callback", bytes.NewReader(body)))
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mailer"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+)
+
+// Lifetime of password reset tokens, PASSWORD_RESET_TTL in config (default 1 hour)
+func passwordResetTTL() time.Duration {
+	if ttl := config.Viper.GetDuration("PASSWORD_RESET_TTL"); ttl > 0 {
+		return ttl
+	}
+	return time.Hour
+}
+
+// Minimum password length, PASSWORD_MIN_LENGTH in config (default 8)
+func passwordMinLength() int {
+	if length := config.Viper.GetInt("PASSWORD_MIN_LENGTH"); length > 0 {
+		return length
+	}
+	return 8
+}
+
+// Check the password policy: long enough, at most 72 bytes (bcrypt limit), contains a letter and a digit
+func checkPasswordPolicy(password string) error {
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		if unicode.IsLetter(c) {
+			hasLetter = true
+		} else if unicode.IsDigit(c) {
+			hasDigit = true
+		}
+	}
+
+	if len([]rune(password)) < passwordMinLength() {
+		return fmt.Errorf("password should be at least %d characters long", passwordMinLength())
+	} else if len(password) > 72 {
+		return errors.New("password should be at most 72 bytes long")
+	} else if !hasLetter || !hasDigit {
+		return errors.New("password should contain both letters and digits")
+	}
+	return nil
+}
+
+func forgotPassword(fpr forgotPasswordRequest) error {
+	var query, UUID string
+	var err error
+
+	// Unknown emails are not reported to avoid account enumeration
+	query = "SELECT UUID FROM User WHERE Email = ?"
+	err = mariadb.DB.QueryRow(query, fpr.Email).Scan(&UUID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] Password reset requested for unknown email: " + fpr.Email)
+			return nil
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Invalidate reset tokens issued before
+	query = "UPDATE Password_Reset SET Used_At = ? WHERE UUID = ? AND Used_At IS NULL"
+	if _, err = mariadb.DB.Exec(query, time.Now().Unix(), UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Generate reset token and store its hash
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	query = "INSERT INTO Password_Reset (Token_Hash, UUID, Expires_At) VALUES (?, ?, ?)"
+	_, err = mariadb.DB.Exec(query, hash, UUID, time.Now().Add(passwordResetTTL()).Unix())
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Send the token to the user, a delivery failure is only logged
+	body := "Use the following token to reset your password, it expires in " + passwordResetTTL().String() + ":\n\n" + token
+	if url := config.Viper.GetString("PASSWORD_RESET_URL"); url != "" {
+		body += "\n\nOr open: " + url + "?token=" + token
+	}
+	body += "\n\nIf you did not request a password reset, you can ignore this mail."
+	_ = mailer.Send(fpr.Email, "Reset your Fortune Tracker password", body)
+
+	logger.Info("[USER] Issued password reset token for UUID: " + UUID)
+
+	return nil
+}
+
+func resetPassword(rpr resetPasswordRequest) error {
+	var query, UUID string
+	var expiresAt int64
+	var usedAt sql.NullInt64
+	var err error
+	hash := auth.HashToken(rpr.Token)
+
+	// Find the reset token
+	query = "SELECT UUID, Expires_At, Used_At FROM Password_Reset WHERE Token_Hash = ?"
+	err = mariadb.DB.QueryRow(query, hash).Scan(&UUID, &expiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] Password reset token not found")
+			return errors.New("invalid or expired reset token")
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	if usedAt.Valid || expiresAt < time.Now().Unix() {
+		logger.Warn("[USER] Password reset token used or expired for UUID: " + UUID)
+		return errors.New("invalid or expired reset token")
+	}
+
+	// Hash password
+	if rpr.Password, err = hashPassword(rpr.Password); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Consume the token and set the new password together
+	tx, err := mariadb.DB.Begin()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	query = "UPDATE Password_Reset SET Used_At = ? WHERE Token_Hash = ? AND Used_At IS NULL"
+	result, err := tx.Exec(query, time.Now().Unix(), hash)
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	if rowsaffected, err := result.RowsAffected(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	} else if rowsaffected == 0 {
+		logger.Warn("[USER] Password reset token used concurrently for UUID: " + UUID)
+		return errors.New("invalid or expired reset token")
+	}
+
+	query = "UPDATE User SET Password = ? WHERE UUID = ?"
+	if _, err = tx.Exec(query, rpr.Password, UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Sign out every device that may still use the old password
+	if err = auth.RevokeAllSessions(UUID, ""); err != nil {
+		return err
+	}
+
+	logger.Info("[USER] Successfully reset password for UUID: " + UUID)
+
+	return nil
+}
+
+func changePassword(UUID, SID string, cpr changePasswordRequest) error {
+	var query, password string
+	var err error
+
+	// Get user password
+	query = "SELECT Password FROM User WHERE UUID = ?"
+	err = mariadb.DB.QueryRow(query, UUID).Scan(&password)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] UUID: " + UUID + " not found")
+			return errors.New("user not found")
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Accounts created through a provider have no password, their first one is set without it
+	// (with the second factor when enabled); otherwise check the current password is correct
+	if password == "" {
+		if enabled, err := isTOTPEnabled(UUID); err != nil {
+			return err
+		} else if enabled {
+			if err = verifySecondFactor(UUID, cpr.Code); err != nil {
+				return err
+			}
+		}
+	} else if !checkPasswordHash(cpr.CurrentPassword, password) {
+		logger.Warn("[USER] Incorrect password for UUID: " + UUID)
+		return errors.New("incorrect password")
+	} else if checkPasswordHash(cpr.NewPassword, password) {
+		logger.Warn("[USER] New password is the current one for UUID: " + UUID)
+		return errors.New("new password should be different from the current one")
+	}
+
+	// Hash password
+	if cpr.NewPassword, err = hashPassword(cpr.NewPassword); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	query = "UPDATE User SET Password = ? WHERE UUID = ?"
+	if _, err = mariadb.DB.Exec(query, cpr.NewPassword, UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Reset tokens issued for the old password are no longer needed
+	query = "UPDATE Password_Reset SET Used_At = ? WHERE UUID = ? AND Used_At IS NULL"
+	if _, err = mariadb.DB.Exec(query, time.Now().Unix(), UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Sign out every other device, the current session stays
+	if err = auth.RevokeAllSessions(UUID, SID); err != nil {
+		return err
+	}
+
+	logger.Info("[USER] Successfully changed password for UUID: " + UUID)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package user
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"Email" binding:"required"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"Token" binding:"required"`
+	Password string `json:"Password" binding:"required"`
+}
+
+type changePasswordRequest struct {
+	// Not needed by accounts created through a provider to set their first password
+	CurrentPassword string `json:"CurrentPassword"`
+	NewPassword     string `json:"NewPassword" binding:"required"`
+	// Required to set the first password when two-factor authentication is enabled
+	Code string `json:"Code"`
+}
+
+func ForgotPassword(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var forgotPasswordRequest forgotPasswordRequest
+	if err = c.ShouldBindJSON(&forgotPasswordRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Send reset token, the response is the same whether the email exists or not
+	if err = forgotPassword(forgotPasswordRequest); err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	r.Message = "If the email is registered, a password reset token has been sent"
+	c.JSON(http.StatusOK, r)
+}
+
+func ResetPassword(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var resetPasswordRequest resetPasswordRequest
+	if err = c.ShouldBindJSON(&resetPasswordRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check the password policy
+	if err = checkPasswordPolicy(resetPasswordRequest.Password); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Reset the password
+	if err = resetPassword(resetPasswordRequest); err != nil {
+		r.Message = err.Error()
+		if r.Message == "invalid or expired reset token" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func ChangePassword(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var changePasswordRequest changePasswordRequest
+	if err = c.ShouldBindJSON(&changePasswordRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check the password policy
+	if err = checkPasswordPolicy(changePasswordRequest.NewPassword); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Change the password
+	if err = changePassword(c.MustGet("UUID").(string), c.MustGet("SID").(string), changePasswordRequest); err != nil {
+		r.Message = err.Error()
+		if r.Message == "user not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "incorrect password" || r.Message == "invalid two-factor code" {
+			c.JSON(http.StatusUnauthorized, r)
+			return
+		} else if r.Message == "new password should be different from the current one" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,271 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/pkg/mailer"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/spf13/viper"
+)
+
+// Replace the database with a mock and use an empty config
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mariadb.DB = db
+	config.Viper = viper.New()
+	return mock
+}
+
+// Matches any argument and keeps it
+type captureArg struct {
+	value driver.Value
+}
+
+func (ca *captureArg) Match(v driver.Value) bool {
+	ca.value = v
+	return true
+}
+
+var mailTokenPattern = regexp.MustCompile(`(?m)^[A-Za-z0-9_-]{43}$`)
+
+// Request a reset token for a known email and return the token found in the sent mail
+func requestResetToken(t *testing.T, mock sqlmock.Sqlmock) string {
+	t.Helper()
+
+	mailPath := filepath.Join(t.TempDir(), "mail.txt")
+	mailer.Mail = &mailer.FileSender{Path: mailPath}
+
+	hash := &captureArg{}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID FROM User WHERE Email = ?")).
+		WithArgs("user@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"UUID"}).AddRow("user-uuid"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Password_Reset SET Used_At = ? WHERE UUID = ? AND Used_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO Password_Reset (Token_Hash, UUID, Expires_At) VALUES (?, ?, ?)")).
+		WithArgs(hash, "user-uuid", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := forgotPassword(forgotPasswordRequest{Email: "user@example.com"}); err != nil {
+		t.Fatalf("forgotPassword: %v", err)
+	}
+
+	mail, err := os.ReadFile(mailPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailTokenPattern.FindString(string(mail))
+	if token == "" {
+		t.Fatalf("no reset token in mail:\n%s", mail)
+	}
+	if hash.value != auth.HashToken(token) {
+		t.Fatalf("stored hash %v does not match the mailed token", hash.value)
+	}
+	return token
+}
+
+func expectResetTokenLookup(mock sqlmock.Sqlmock, token string, expiresAt int64, usedAt interface{}) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID, Expires_At, Used_At FROM Password_Reset WHERE Token_Hash = ?")).
+		WithArgs(auth.HashToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"UUID", "Expires_At", "Used_At"}).AddRow("user-uuid", expiresAt, usedAt))
+}
+
+func TestPasswordResetTokenIsSingleUse(t *testing.T) {
+	mock := setupMockDB(t)
+	token := requestResetToken(t, mock)
+	expiresAt := time.Now().Add(time.Hour).Unix()
+
+	// First use consumes the token, sets the password and signs out every session
+	expectResetTokenLookup(mock, token, expiresAt, nil)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Password_Reset SET Used_At = ? WHERE Token_Hash = ? AND Used_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), auth.HashToken(token)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE User SET Password = ? WHERE UUID = ?")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Session SET Revoked_At = ? WHERE UUID = ? AND SID != ? AND Revoked_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid", "").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := resetPassword(resetPasswordRequest{Token: token, Password: "newpassw0rd"}); err != nil {
+		t.Fatalf("first reset: %v", err)
+	}
+
+	// Second use finds the token consumed and changes nothing
+	expectResetTokenLookup(mock, token, expiresAt, time.Now().Unix())
+
+	if err := resetPassword(resetPasswordRequest{Token: token, Password: "otherpassw0rd"}); err == nil || err.Error() != "invalid or expired reset token" {
+		t.Fatalf("second reset: got %v, want invalid or expired reset token", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordResetTokenUsedConcurrently(t *testing.T) {
+	mock := setupMockDB(t)
+	token := requestResetToken(t, mock)
+
+	// Another request consumed the token between the lookup and the update
+	expectResetTokenLookup(mock, token, time.Now().Add(time.Hour).Unix(), nil)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Password_Reset SET Used_At = ? WHERE Token_Hash = ? AND Used_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), auth.HashToken(token)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := resetPassword(resetPasswordRequest{Token: token, Password: "newpassw0rd"}); err == nil || err.Error() != "invalid or expired reset token" {
+		t.Fatalf("got %v, want invalid or expired reset token", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordResetTokenExpires(t *testing.T) {
+	mock := setupMockDB(t)
+	token := requestResetToken(t, mock)
+
+	// The token expired before it was used, the password is left untouched
+	expectResetTokenLookup(mock, token, time.Now().Add(-time.Minute).Unix(), nil)
+
+	if err := resetPassword(resetPasswordRequest{Token: token, Password: "newpassw0rd"}); err == nil || err.Error() != "invalid or expired reset token" {
+		t.Fatalf("got %v, want invalid or expired reset token", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordResetTokenExpiryFollowsConfig(t *testing.T) {
+	mock := setupMockDB(t)
+	config.Viper.Set("PASSWORD_RESET_TTL", "15m")
+
+	mailer.Mail = &mailer.FileSender{Path: filepath.Join(t.TempDir(), "mail.txt")}
+	expiresAt := &captureArg{}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID FROM User WHERE Email = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"UUID"}).AddRow("user-uuid"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Password_Reset SET Used_At = ?")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO Password_Reset")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid", expiresAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	before := time.Now().Unix()
+	if err := forgotPassword(forgotPasswordRequest{Email: "user@example.com"}); err != nil {
+		t.Fatalf("forgotPassword: %v", err)
+	}
+
+	if got, want := expiresAt.value.(int64), before+15*60; got < want || got > want+1 {
+		t.Fatalf("token expires at %d, want about %d", got, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	mock := setupMockDB(t)
+	mailPath := filepath.Join(t.TempDir(), "mail.txt")
+	mailer.Mail = &mailer.FileSender{Path: mailPath}
+
+	// Unknown emails get the same result and no mail
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT UUID FROM User WHERE Email = ?")).
+		WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"UUID"}))
+
+	if err := forgotPassword(forgotPasswordRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("forgotPassword: %v", err)
+	}
+	if _, err := os.Stat(mailPath); !os.IsNotExist(err) {
+		t.Fatalf("a mail was sent for an unknown email")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFirstPasswordOfProviderAccount(t *testing.T) {
+	mock := setupMockDB(t)
+
+	// Accounts created through a provider set their first password without a current one
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Password FROM User WHERE UUID = ?")).
+		WithArgs("user-uuid").
+		WillReturnRows(sqlmock.NewRows([]string{"Password"}).AddRow(""))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Enabled FROM User_TOTP WHERE UUID = ?")).
+		WithArgs("user-uuid").
+		WillReturnRows(sqlmock.NewRows([]string{"Enabled"}))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE User SET Password = ? WHERE UUID = ?")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Password_Reset SET Used_At = ? WHERE UUID = ? AND Used_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Session SET Revoked_At = ? WHERE UUID = ? AND SID != ? AND Revoked_At IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "user-uuid", "current-sid").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := changePassword("user-uuid", "current-sid", changePasswordRequest{NewPassword: "newpassw0rd"}); err != nil {
+		t.Fatalf("changePassword: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFirstPasswordOfProviderAccountNeedsSecondFactor(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Password FROM User WHERE UUID = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"Password"}).AddRow(""))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Enabled FROM User_TOTP WHERE UUID = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"Enabled"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Secret, Last_Used_Step FROM User_TOTP WHERE UUID = ? AND Enabled = TRUE")).
+		WillReturnRows(sqlmock.NewRows([]string{"Secret", "Last_Used_Step"}).AddRow("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE Recovery_Code SET Used_At = ?")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Without a valid code the password is left unset
+	err := changePassword("user-uuid", "current-sid", changePasswordRequest{NewPassword: "newpassw0rd", Code: "not-a-code"})
+	if err == nil || err.Error() != "invalid two-factor code" {
+		t.Fatalf("got %v, want invalid two-factor code", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProviderAccountWithoutPasswordCanNotBeDeletedYet(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT Email, Password FROM User WHERE UUID = ?")).
+		WithArgs("user-uuid").
+		WillReturnRows(sqlmock.NewRows([]string{"Email", "Password"}).AddRow("user@example.com", ""))
+
+	if err := deleteAccount("user-uuid", deleteAccountRequest{Password: ""}); err == nil || err.Error() != "password not set" {
+		t.Fatalf("got %v, want password not set", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
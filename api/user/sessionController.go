@@ -0,0 +1,47 @@
+package user
+
+import (
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListSessions(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Get the active sessions, marking the one of the current token
+	sessions, err := auth.ListSessions(c.MustGet("UUID").(string), c.MustGet("SID").(string))
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return sessions with formatted response
+	r.Status = true
+	r.Data = sessions
+	c.JSON(http.StatusOK, r)
+}
+
+func RevokeSession(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Revoke the session
+	if err := auth.RevokeUserSession(c.MustGet("UUID").(string), c.Param("sid")); err != nil {
+		r.Message = err.Error()
+		if r.Message == "session not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,247 @@
+package user
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/totp"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+func totpIssuer() string {
+	if issuer := config.Viper.GetString("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Fortune Tracker"
+}
+
+func isTOTPEnabled(UUID string) (bool, error) {
+	var enabled bool
+
+	query := "SELECT Enabled FROM User_TOTP WHERE UUID = ?"
+	err := mariadb.DB.QueryRow(query, UUID).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		logger.Error("[USER] " + err.Error())
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// Start TOTP enrollment, the secret is only enabled after a code is confirmed
+func enrollTOTP(UUID string) (string, string, error) {
+	var query, email string
+	var err error
+
+	if enabled, err := isTOTPEnabled(UUID); err != nil {
+		return "", "", err
+	} else if enabled {
+		logger.Warn("[USER] TOTP already enabled for UUID: " + UUID)
+		return "", "", errors.New("two-factor authentication already enabled")
+	}
+
+	// Get user email for the account label
+	query = "SELECT Email FROM User WHERE UUID = ?"
+	if err = mariadb.DB.QueryRow(query, UUID).Scan(&email); err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] UUID: " + UUID + " not found")
+			return "", "", errors.New("user not found")
+		}
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	// Replace any unconfirmed enrollment
+	query = "REPLACE INTO User_TOTP (UUID, Secret, Enabled, Last_Used_Step, Created_At) VALUES (?, ?, FALSE, 0, ?)"
+	if _, err = mariadb.DB.Exec(query, UUID, secret, time.Now().Unix()); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return "", "", err
+	}
+
+	logger.Info("[USER] Started TOTP enrollment for UUID: " + UUID)
+
+	return secret, totp.ProvisioningURI(totpIssuer(), email, secret), nil
+}
+
+// Confirm the enrollment with a first code, returns the recovery codes
+func confirmTOTP(UUID, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+
+	query := "SELECT Secret, Enabled FROM User_TOTP WHERE UUID = ?"
+	err := mariadb.DB.QueryRow(query, UUID).Scan(&secret, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] No TOTP enrollment for UUID: " + UUID)
+			return nil, errors.New("two-factor authentication is not being enrolled")
+		}
+		logger.Error("[USER] " + err.Error())
+		return nil, err
+	} else if enabled {
+		logger.Warn("[USER] TOTP already enabled for UUID: " + UUID)
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		logger.Warn("[USER] Invalid TOTP code during enrollment for UUID: " + UUID)
+		return nil, errors.New("invalid two-factor code")
+	}
+
+	query = "UPDATE User_TOTP SET Enabled = TRUE, Last_Used_Step = ? WHERE UUID = ?"
+	if _, err = mariadb.DB.Exec(query, step, UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return nil, err
+	}
+
+	codes, err := regenerateRecoveryCodes(UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("[USER] Enabled TOTP for UUID: " + UUID)
+
+	return codes, nil
+}
+
+func disableTOTP(UUID string, dtr disableTOTPRequest) error {
+	var password string
+
+	// Both the password and a second factor are required
+	query := "SELECT Password FROM User WHERE UUID = ?"
+	if err := mariadb.DB.QueryRow(query, UUID).Scan(&password); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	if password == "" {
+		logger.Warn("[USER] No password set for UUID: " + UUID)
+		return errors.New("password not set")
+	} else if !checkPasswordHash(dtr.Password, password) {
+		logger.Warn("[USER] Incorrect password for UUID: " + UUID)
+		return errors.New("incorrect password")
+	}
+	if err := verifySecondFactor(UUID, dtr.Code); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM User_TOTP WHERE UUID = ?",
+		"DELETE FROM Recovery_Code WHERE UUID = ?",
+	} {
+		if _, err := mariadb.DB.Exec(query, UUID); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+	}
+
+	logger.Info("[USER] Disabled TOTP for UUID: " + UUID)
+
+	return nil
+}
+
+// Verify a TOTP code, or else a recovery code which is then consumed
+func verifySecondFactor(UUID, code string) error {
+	var secret string
+	var lastUsedStep int64
+
+	query := "SELECT Secret, Last_Used_Step FROM User_TOTP WHERE UUID = ? AND Enabled = TRUE"
+	err := mariadb.DB.QueryRow(query, UUID).Scan(&secret, &lastUsedStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] TOTP not enabled for UUID: " + UUID)
+			return errors.New("two-factor authentication is not enabled")
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// A TOTP code can only be used once
+	if step, ok := totp.Validate(secret, code, time.Now()); ok && step > lastUsedStep {
+		query = "UPDATE User_TOTP SET Last_Used_Step = ? WHERE UUID = ? AND Last_Used_Step < ?"
+		result, err := mariadb.DB.Exec(query, step, UUID, step)
+		if err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+		if rowsaffected, _ := result.RowsAffected(); rowsaffected == 1 {
+			return nil
+		}
+	}
+
+	// Try the code as a recovery code
+	query = "UPDATE Recovery_Code SET Used_At = ? WHERE Code_Hash = ? AND UUID = ? AND Used_At IS NULL"
+	result, err := mariadb.DB.Exec(query, time.Now().Unix(), auth.HashToken(normalizeRecoveryCode(code)), UUID)
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	if rowsaffected, _ := result.RowsAffected(); rowsaffected == 1 {
+		logger.Info("[USER] Recovery code used for UUID: " + UUID)
+		return nil
+	}
+
+	logger.Warn("[USER] Invalid two-factor code for UUID: " + UUID)
+	return errors.New("invalid two-factor code")
+}
+
+// Replace the recovery codes of the user, only their hashes are stored
+func regenerateRecoveryCodes(UUID string) ([]string, error) {
+	tx, err := mariadb.DB.Begin()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM Recovery_Code WHERE UUID = ?", UUID); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+
+		query := "INSERT INTO Recovery_Code (Code_Hash, UUID) VALUES (?, ?)"
+		if _, err = tx.Exec(query, auth.HashToken(normalizeRecoveryCode(code)), UUID); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -0,0 +1,177 @@
+package user
+
+import (
+	"Fortune_Tracker_API/internal/auth"
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type confirmTOTPRequest struct {
+	Code string `json:"Code" binding:"required"`
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"Password" binding:"required"`
+	Code     string `json:"Code" binding:"required"`
+}
+
+type loginTwoFactorRequest struct {
+	ChallengeToken string `json:"ChallengeToken" binding:"required"`
+	Code           string `json:"Code" binding:"required"`
+	DeviceName     string `json:"DeviceName"`
+}
+
+func EnrollTOTP(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Generate a new secret
+	secret, uri, err := enrollTOTP(c.MustGet("UUID").(string))
+	if err != nil {
+		r.Message = err.Error()
+		if r.Message == "user not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "two-factor authentication already enabled" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return secret with formatted response
+	r.Status = true
+	r.Data = response.TOTPEnrollResponse{Secret: secret, ProvisioningURI: uri}
+	c.JSON(http.StatusOK, r)
+}
+
+func ConfirmTOTP(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var confirmTOTPRequest confirmTOTPRequest
+	if err = c.ShouldBindJSON(&confirmTOTPRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Enable TOTP
+	codes, err := confirmTOTP(c.MustGet("UUID").(string), confirmTOTPRequest.Code)
+	if err != nil {
+		r.Message = err.Error()
+		if r.Message == "two-factor authentication is not being enrolled" ||
+			r.Message == "two-factor authentication already enabled" ||
+			r.Message == "invalid two-factor code" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return recovery codes with formatted response
+	r.Status = true
+	r.Data = response.RecoveryCodesResponse{RecoveryCodes: codes}
+	c.JSON(http.StatusOK, r)
+}
+
+func DisableTOTP(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var disableTOTPRequest disableTOTPRequest
+	if err = c.ShouldBindJSON(&disableTOTPRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Disable TOTP
+	if err = disableTOTP(c.MustGet("UUID").(string), disableTOTPRequest); err != nil {
+		r.Message = err.Error()
+		if r.Message == "incorrect password" || r.Message == "invalid two-factor code" {
+			c.JSON(http.StatusUnauthorized, r)
+			return
+		} else if r.Message == "password not set" {
+			r.Message = "password not set, set one with PUT /user/password first"
+			c.JSON(http.StatusConflict, r)
+			return
+		} else if r.Message == "two-factor authentication is not enabled" {
+			c.JSON(http.StatusBadRequest, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func LoginTwoFactor(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var loginTwoFactorRequest loginTwoFactorRequest
+	if err = c.ShouldBindJSON(&loginTwoFactorRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Check the challenge of the password step
+	UUID, email, err := auth.ParseChallengeToken(loginTwoFactorRequest.ChallengeToken)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusUnauthorized, r)
+		return
+	}
+
+	// Wrong codes count as failed logins too
+	if !checkLoginLock(c, r, email) {
+		return
+	}
+
+	// Check the second factor
+	if err = verifySecondFactor(UUID, loginTwoFactorRequest.Code); err != nil {
+		r.Message = err.Error()
+		if r.Message == "invalid two-factor code" || r.Message == "two-factor authentication is not enabled" {
+			if err = recordLoginFailure(email, c.ClientIP()); err != nil {
+				r.Message = err.Error()
+				c.JSON(http.StatusInternalServerError, r)
+				return
+			}
+			c.JSON(http.StatusUnauthorized, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	if err = clearLoginFailures(email); err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// Start a new session
+	startSession(c, r, UUID, email, loginTwoFactorRequest.DeviceName)
+}
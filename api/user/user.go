@@ -1,19 +1,39 @@
 package user
 
 import (
+	"Fortune_Tracker_API/api/ledger"
+	"Fortune_Tracker_API/config"
 	"Fortune_Tracker_API/pkg/logger"
 	"Fortune_Tracker_API/pkg/mariadb"
+	"database/sql"
 	"errors"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserInfo struct {
-	UUID     string `json:"UUID"`
-	Username string `json:"Username"`
-	Email    string `json:"Email"`
-	Is_Pro   bool   `json:"Is_Pro"`
+	UUID           string `json:"UUID"`
+	Username       string `json:"Username"`
+	Email          string `json:"Email"`
+	Email_Verified bool   `json:"Email_Verified"`
+	Is_Pro         bool   `json:"Is_Pro"`
+}
+
+// Tables holding rows of a user, cleared when the account is deleted (User last)
+var userTables = []string{
+	"Refresh_Token",
+	"Session",
+	"Password_Reset",
+	"Email_Verification",
+	"User_TOTP",
+	"Recovery_Code",
+	"User_Identity",
+	"API_Token",
+	"User_Info",
+	"User",
 }
 
 type User struct {
@@ -31,6 +51,17 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+var dummyHash string
+var dummyHashOnce sync.Once
+
+// A hash to check passwords against when the user does not exist
+func getDummyHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = hashPassword(uuid.NewString())
+	})
+	return dummyHash
+}
+
 func register(rr registerRequest) (string, error) {
 	var query, UUID, email string
 	var err error
@@ -71,6 +102,9 @@ func register(rr registerRequest) (string, error) {
 		return "", err
 	}
 
+	// Send email verification, the user can ask for another one if delivery fails
+	_ = sendEmailVerification(UUID, rr.Email)
+
 	logger.Info("[USER] Successfully registered user with email: " + rr.Email)
 
 	return UUID, nil
@@ -94,8 +128,8 @@ func get(UUID string) (UserInfo, error) {
 	}
 
 	// Get user email
-	query = "SELECT Email FROM User WHERE UUID = ?"
-	err = mariadb.DB.QueryRow(query, UUID).Scan(&userInfo.Email)
+	query = "SELECT Email, Email_Verified FROM User WHERE UUID = ?"
+	err = mariadb.DB.QueryRow(query, UUID).Scan(&userInfo.Email, &userInfo.Email_Verified)
 	if err != nil {
 		logger.Error("[USER] " + err.Error())
 		return userInfo, err
@@ -106,51 +140,68 @@ func get(UUID string) (UserInfo, error) {
 	return userInfo, nil
 }
 
-func update(ur updateRequest) error {
-	var query string
+// Update user info, returns whether an email change is waiting for verification
+func update(ur updateRequest) (bool, error) {
+	var query, email string
 
 	// Update user info
 	query = "UPDATE User_Info SET Username = ?, Is_Pro = ? WHERE UUID = ?"
 	result, err := mariadb.DB.Exec(query, ur.Username, ur.Is_Pro, ur.UUID)
 	if err != nil {
 		logger.Error("[USER] " + err.Error())
-		return err
+		return false, err
 	}
 
 	// Check if UUID exists
 	rowsaffected, err := result.RowsAffected()
 	if err != nil {
 		logger.Error("[USER] " + err.Error())
-		return err
+		return false, err
 	} else if rowsaffected == 0 {
 		logger.Warn("[USER] UUID: " + ur.UUID + " not found")
-		return errors.New("user not found")
+		return false, errors.New("user not found")
 	}
 
-	// Update user email
-	query = "UPDATE User SET Email = ? WHERE UUID = ?"
-	_, err = mariadb.DB.Exec(query, ur.Email, ur.UUID)
-	if err != nil {
+	logger.Info("[USER] Successfully updated user info for UUID: " + ur.UUID)
+
+	// Get user email
+	query = "SELECT Email FROM User WHERE UUID = ?"
+	if err = mariadb.DB.QueryRow(query, ur.UUID).Scan(&email); err != nil {
 		logger.Error("[USER] " + err.Error())
-		return err
+		return false, err
+	} else if email == ur.Email {
+		return false, nil
 	}
 
-	logger.Info("[USER] Successfully updated user info for UUID: " + ur.UUID)
+	// A new email only takes effect once it has been verified
+	if taken, err := emailTaken(ur.Email, ur.UUID); err != nil {
+		return false, err
+	} else if taken {
+		logger.Warn("[USER] Email:" + ur.Email + " already exists")
+		return false, errors.New("email already exists")
+	}
 
-	return nil
+	if err = sendEmailVerification(ur.UUID, ur.Email); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 func login(lr loginRequest) (string, error) {
 	var query, UUID, password string
+	var verified bool
 	var err error
 
 	// Get user password
-	query = "SELECT UUID, Password FROM User WHERE Email = ?"
-	err = mariadb.DB.QueryRow(query, lr.Email).Scan(&UUID, &password)
+	query = "SELECT UUID, Password, Email_Verified FROM User WHERE Email = ?"
+	err = mariadb.DB.QueryRow(query, lr.Email).Scan(&UUID, &password, &verified)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
+			// Spend the same time as a password check so unknown emails can not be told apart
+			checkPasswordHash(lr.Password, getDummyHash())
 			logger.Warn("[USER] Email: " + lr.Email + " not found")
-			return "", errors.New("user not found")
+			return "", errors.New("invalid email or password")
 		}
 		logger.Error("[USER] " + err.Error())
 		return "", err
@@ -159,10 +210,85 @@ func login(lr loginRequest) (string, error) {
 	// Check if password is correct
 	if !checkPasswordHash(lr.Password, password) {
 		logger.Warn("[USER] Incorrect password for Email: " + lr.Email)
-		return "", errors.New("incorrect password")
+		return "", errors.New("invalid email or password")
+	}
+
+	// Unverified accounts can be kept from logging in (REQUIRE_EMAIL_VERIFICATION)
+	if !verified && config.Viper.GetBool("REQUIRE_EMAIL_VERIFICATION") {
+		logger.Warn("[USER] Email not verified for Email: " + lr.Email)
+		return "", errors.New("email not verified")
 	}
 
 	logger.Info("[USER] Successfully logged in user with email: " + lr.Email)
 
 	return UUID, nil
 }
+
+func deleteAccount(UUID string, dar deleteAccountRequest) error {
+	var query, email, password string
+	var err error
+
+	// Get user password
+	query = "SELECT Email, Password FROM User WHERE UUID = ?"
+	err = mariadb.DB.QueryRow(query, UUID).Scan(&email, &password)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[USER] UUID: " + UUID + " not found")
+			return errors.New("user not found")
+		}
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	// Deleting the account needs the password, and the second factor when enabled
+	if password == "" {
+		logger.Warn("[USER] No password set for UUID: " + UUID)
+		return errors.New("password not set")
+	} else if !checkPasswordHash(dar.Password, password) {
+		logger.Warn("[USER] Incorrect password for UUID: " + UUID)
+		return errors.New("incorrect password")
+	}
+	if enabled, err := isTOTPEnabled(UUID); err != nil {
+		return err
+	} else if enabled {
+		if err = verifySecondFactor(UUID, dar.Code); err != nil {
+			return err
+		}
+	}
+
+	// Remove the user from ledgers first, the account is kept if this fails so it can be retried
+	if err = ledger.RemoveUser(UUID, email); err != nil {
+		return err
+	}
+
+	// Delete every row of the user
+	tx, err := mariadb.DB.Begin()
+	if err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range userTables {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE UUID = ?", UUID); err != nil {
+			logger.Error("[USER] " + err.Error())
+			return err
+		}
+	}
+
+	// Failed logins are counted by email
+	query = "DELETE FROM Login_Failure WHERE Kind = ? AND Identifier = ?"
+	if _, err = tx.Exec(query, throttleAccount, strings.ToLower(email)); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error("[USER] " + err.Error())
+		return err
+	}
+
+	logger.Info("[USER] Successfully deleted user with UUID: " + UUID)
+
+	return nil
+}
@@ -6,7 +6,7 @@ import (
 	"Fortune_Tracker_API/pkg/logger"
 	"net/http"
 	"regexp"
-	"strings"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,8 +25,19 @@ type updateRequest struct {
 }
 
 type loginRequest struct {
-	Email    string `json:"Email" binding:"required"`
+	Email      string `json:"Email" binding:"required"`
+	Password   string `json:"Password" binding:"required"`
+	DeviceName string `json:"DeviceName"`
+}
+
+type deleteAccountRequest struct {
 	Password string `json:"Password" binding:"required"`
+	// Required when two-factor authentication is enabled
+	Code string `json:"Code"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"RefreshToken" binding:"required"`
 }
 
 func Register(c *gin.Context) {
@@ -44,14 +55,22 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// Check pass in fields (Email has @ symbol)
-	if !strings.Contains(registerRequest.Email, "@") {
+	// Check pass in fields (Email is a valid address)
+	if !isValidEmail(registerRequest.Email) {
 		logger.Warn("[USER] Invalid email address")
 		r.Message = "Invalid email address"
 		c.JSON(http.StatusBadRequest, r)
 		return
 	}
 
+	// Check the password policy
+	if err = checkPasswordPolicy(registerRequest.Password); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
 	// Register the user
 	UUID, err := register(registerRequest)
 	if err != nil {
@@ -126,13 +145,24 @@ func Update(c *gin.Context) {
 		return
 	}
 
+	// Check pass in fields (Email is a valid address)
+	if !isValidEmail(updateRequest.Email) {
+		logger.Warn("[USER] Invalid email address")
+		r.Message = "Invalid email address"
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
 	// Update user info
-	err = update(updateRequest)
+	emailPending, err := update(updateRequest)
 	if err != nil {
 		r.Message = err.Error()
 		if r.Message == "user not found" {
 			c.JSON(http.StatusNotFound, r)
 			return
+		} else if r.Message == "email already exists" {
+			c.JSON(http.StatusConflict, r)
+			return
 		}
 		c.JSON(http.StatusInternalServerError, r)
 		return
@@ -140,6 +170,9 @@ func Update(c *gin.Context) {
 
 	// return formatted response
 	r.Status = true
+	if emailPending {
+		r.Message = "A verification token has been sent to the new email, it will be used once verified"
+	}
 	c.JSON(http.StatusOK, r)
 }
 
@@ -158,31 +191,221 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse attempts while the account or the client IP is locked out
+	if !checkLoginLock(c, r, loginRequest.Email) {
+		return
+	}
+
 	// Login the user
 	UUID, err := login(loginRequest)
 	if err != nil {
 		r.Message = err.Error()
-		if r.Message == "user not found" {
-			c.JSON(http.StatusNotFound, r)
-			return
-		} else if r.Message == "incorrect password" {
+		if r.Message == "invalid email or password" {
+			if err = recordLoginFailure(loginRequest.Email, c.ClientIP()); err != nil {
+				r.Message = err.Error()
+				c.JSON(http.StatusInternalServerError, r)
+				return
+			}
 			c.JSON(http.StatusUnauthorized, r)
 			return
+		} else if r.Message == "email not verified" {
+			c.JSON(http.StatusForbidden, r)
+			return
 		}
 		c.JSON(http.StatusInternalServerError, r)
 		return
 	}
 
-	// Generate token
-	token, err := auth.GenerateToken(UUID, loginRequest.Email)
+	// Second factor if enabled, then tokens
+	completeLogin(c, r, UUID, loginRequest.Email, loginRequest.DeviceName)
+}
+
+// Finish a login whose first factor succeeded: users with two-factor authentication
+// get a challenge, the others a new session. Failed logins of the account are only
+// forgotten once the login is complete, so wrong codes keep counting for 2FA users.
+func completeLogin(c *gin.Context, r *response.Response, UUID, email, deviceName string) {
+	enabled, err := isTOTPEnabled(UUID)
 	if err != nil {
 		r.Message = err.Error()
 		c.JSON(http.StatusInternalServerError, r)
 		return
+	} else if !enabled {
+		if err = clearLoginFailures(email); err != nil {
+			r.Message = err.Error()
+			c.JSON(http.StatusInternalServerError, r)
+			return
+		}
+		startSession(c, r, UUID, email, deviceName)
+		return
 	}
 
-	// return UUID with formatted response
+	challenge, expiresAt, err := auth.GenerateChallengeToken(UUID, email)
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	r.Status = true
+	r.Message = "two-factor authentication required"
+	r.Data = response.TwoFactorChallengeResponse{
+		UUID:              UUID,
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresAt:         expiresAt,
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// Respond 429 and return false when logins for the email or from the client IP are locked
+func checkLoginLock(c *gin.Context, r *response.Response, email string) bool {
+	remaining, err := loginLockedFor(email, c.ClientIP())
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return false
+	} else if remaining > 0 {
+		logger.Warn("[USER] Login attempt while locked out for Email: " + email + " (IP: " + c.ClientIP() + ")")
+		r.Message = "too many failed login attempts, try again later"
+		c.Header("Retry-After", strconv.Itoa(int(remaining.Seconds())))
+		c.JSON(http.StatusTooManyRequests, r)
+		return false
+	}
+	return true
+}
+
+// Start a new session and return its tokens with formatted response
+func startSession(c *gin.Context, r *response.Response, UUID, email, deviceName string) {
+	tokens, err := auth.NewSession(UUID, email, auth.Device{
+		Name:      deviceName,
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	})
+	if err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	r.Status = true
+	r.Data = response.LoginResponse{
+		UUID:         UUID,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+func RefreshToken(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var refreshTokenRequest refreshTokenRequest
+	if err = c.ShouldBindJSON(&refreshTokenRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Rotate the refresh token
+	tokens, err := auth.Refresh(refreshTokenRequest.RefreshToken, auth.Device{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	})
+	if err != nil {
+		r.Message = err.Error()
+		if r.Message == "invalid refresh token" ||
+			r.Message == "refresh token is expired" ||
+			r.Message == "refresh token reuse detected" ||
+			r.Message == "session has been revoked" {
+			c.JSON(http.StatusUnauthorized, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return new tokens with formatted response
+	r.Status = true
+	r.Data = response.TokenResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+func Logout(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Revoke the session of the current token
+	if err := auth.RevokeSession(c.MustGet("SID").(string)); err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func LogoutAll(c *gin.Context) {
+	// Create response
+	r := response.New()
+
+	// Revoke every session of the user, including the current one
+	if err := auth.RevokeAllSessions(c.MustGet("UUID").(string), ""); err != nil {
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
+	r.Status = true
+	c.JSON(http.StatusOK, r)
+}
+
+func Delete(c *gin.Context) {
+	var err error
+
+	// Create response
+	r := response.New()
+
+	// Parse request body to JSON format
+	var deleteAccountRequest deleteAccountRequest
+	if err = c.ShouldBindJSON(&deleteAccountRequest); err != nil {
+		logger.Warn("[USER] " + err.Error())
+		r.Message = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+
+	// Delete the account
+	if err = deleteAccount(c.MustGet("UUID").(string), deleteAccountRequest); err != nil {
+		r.Message = err.Error()
+		if r.Message == "user not found" {
+			c.JSON(http.StatusNotFound, r)
+			return
+		} else if r.Message == "incorrect password" || r.Message == "invalid two-factor code" {
+			c.JSON(http.StatusUnauthorized, r)
+			return
+		} else if r.Message == "password not set" {
+			r.Message = "password not set, set one with PUT /user/password first"
+			c.JSON(http.StatusConflict, r)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, r)
+		return
+	}
+
+	// return formatted response
 	r.Status = true
-	r.Data = response.LoginResponse{UUID: UUID, Token: token}
 	c.JSON(http.StatusOK, r)
 }
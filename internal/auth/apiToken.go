@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"Fortune_Tracker_API/internal/response"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Personal API tokens are recognized by this prefix
+const apiTokenPrefix = "ftk_"
+
+// Scopes of API tokens, "ledger:<ULID>" scopes restrict a token to the listed ledgers
+const (
+	ScopeRead              = "read"
+	ScopeLedgersWrite      = "ledgers:write"
+	ScopeTransactionsWrite = "transactions:write"
+	scopeLedgerPrefix      = "ledger:"
+)
+
+type APIToken struct {
+	ATID       string   `json:"ATID"`
+	Name       string   `json:"Name"`
+	Scopes     []string `json:"Scopes"`
+	Token      string   `json:"Token,omitempty"` // only returned on creation
+	CreatedAt  int64    `json:"CreatedAt"`
+	LastUsedAt *int64   `json:"LastUsedAt"`
+	ExpiresAt  *int64   `json:"ExpiresAt"`
+}
+
+// Check every scope is known
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if scope == ScopeRead || scope == ScopeLedgersWrite || scope == ScopeTransactionsWrite {
+			continue
+		}
+		if ULID, ok := strings.CutPrefix(scope, scopeLedgerPrefix); ok {
+			if matched, _ := regexp.MatchString("^[a-z0-9-]{36}$", ULID); matched {
+				continue
+			}
+		}
+		return errors.New("unknown scope: " + scope)
+	}
+	return nil
+}
+
+// Create an API token, the plain token is only returned here
+func CreateAPIToken(UUID, name string, scopes []string, expiresAt *int64) (APIToken, error) {
+	token, _, err := NewOpaqueToken()
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return APIToken{}, err
+	}
+
+	apiToken := APIToken{
+		ATID:      uuid.NewString(),
+		Name:      name,
+		Scopes:    scopes,
+		Token:     apiTokenPrefix + token,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+
+	query := "INSERT INTO API_Token (ATID, UUID, Name, Token_Hash, Scopes, Created_At, Expires_At) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err = mariadb.DB.Exec(query, apiToken.ATID, UUID, name, HashToken(apiToken.Token), strings.Join(scopes, " "), apiToken.CreatedAt, expiresAt)
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return APIToken{}, err
+	}
+
+	logger.Info("[AUTH] Created API token: " + apiToken.ATID + " for user: " + UUID)
+
+	return apiToken, nil
+}
+
+// List the API tokens of a user which are not revoked
+func ListAPITokens(UUID string) ([]APIToken, error) {
+	apiTokens := []APIToken{}
+
+	query := "SELECT ATID, Name, Scopes, Created_At, Last_Used_At, Expires_At FROM API_Token WHERE UUID = ? AND Revoked_At IS NULL ORDER BY Created_At"
+	rows, err := mariadb.DB.Query(query, UUID)
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return apiTokens, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var apiToken APIToken
+		var scopes string
+		var lastUsedAt, expiresAt sql.NullInt64
+		if err = rows.Scan(&apiToken.ATID, &apiToken.Name, &scopes, &apiToken.CreatedAt, &lastUsedAt, &expiresAt); err != nil {
+			logger.Error("[AUTH] " + err.Error())
+			return apiTokens, err
+		}
+		apiToken.Scopes = strings.Fields(scopes)
+		if lastUsedAt.Valid {
+			apiToken.LastUsedAt = &lastUsedAt.Int64
+		}
+		if expiresAt.Valid {
+			apiToken.ExpiresAt = &expiresAt.Int64
+		}
+		apiTokens = append(apiTokens, apiToken)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return apiTokens, err
+	}
+
+	return apiTokens, nil
+}
+
+// Revoke an API token of the user
+func RevokeAPIToken(UUID, ATID string) error {
+	query := "UPDATE API_Token SET Revoked_At = ? WHERE ATID = ? AND UUID = ? AND Revoked_At IS NULL"
+	result, err := mariadb.DB.Exec(query, time.Now().Unix(), ATID, UUID)
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	}
+
+	if rowsaffected, err := result.RowsAffected(); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	} else if rowsaffected == 0 {
+		logger.Warn("[AUTH] API token: " + ATID + " not found")
+		return errors.New("API token not found")
+	}
+
+	logger.Info("[AUTH] Revoked API token: " + ATID)
+	return nil
+}
+
+// Authenticate an API token, returns its owner and scopes
+func lookupAPIToken(token string) (string, string, []string, error) {
+	var ATID, UUID, scopes string
+	var expiresAt, revokedAt sql.NullInt64
+	now := time.Now().Unix()
+
+	query := "SELECT ATID, UUID, Scopes, Expires_At, Revoked_At FROM API_Token WHERE Token_Hash = ?"
+	err := mariadb.DB.QueryRow(query, HashToken(token)).Scan(&ATID, &UUID, &scopes, &expiresAt, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[AUTH] API token not found")
+			return "", "", nil, errors.New("invalid API token")
+		}
+		logger.Error("[AUTH] " + err.Error())
+		return "", "", nil, err
+	}
+	if revokedAt.Valid {
+		logger.Warn("[AUTH] Received revoked API token: " + ATID)
+		return "", "", nil, errors.New("API token has been revoked")
+	} else if expiresAt.Valid && expiresAt.Int64 < now {
+		logger.Warn("[AUTH] Received expired API token: " + ATID)
+		return "", "", nil, errors.New("API token is expired")
+	}
+
+	// Record the use, at most once a minute
+	query = "UPDATE API_Token SET Last_Used_At = ? WHERE ATID = ? AND (Last_Used_At IS NULL OR Last_Used_At < ?)"
+	if _, err = mariadb.DB.Exec(query, now, ATID, now-60); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return "", "", nil, err
+	}
+
+	return ATID, UUID, strings.Fields(scopes), nil
+}
+
+// Check the scopes of an API token allow the route of the request
+func scopesAllow(c *gin.Context, scopes []string) bool {
+	granted := make(map[string]bool)
+	ledgers := make(map[string]bool)
+	for _, scope := range scopes {
+		if ULID, ok := strings.CutPrefix(scope, scopeLedgerPrefix); ok {
+			ledgers[ULID] = true
+		} else {
+			granted[scope] = true
+		}
+	}
+
+	// Ledger restricted tokens can only reach the listed ledgers,
+	// and change nothing outside of them (creating ledgers, deleting templates, ...)
+	path := c.FullPath()
+	write := c.Request.Method != http.MethodGet
+	if len(ledgers) > 0 {
+		if ULID := c.Param("ulid"); ULID != "" && !ledgers[ULID] {
+			return false
+		} else if ULID == "" && write {
+			return false
+		}
+		c.Set("LedgerScopes", ledgers)
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/ledger/:ulid/transaction") || path == "/ledger/:ulid/settlement":
+		if write {
+			return granted[ScopeTransactionsWrite]
+		}
+		return granted[ScopeRead]
+	case path == "/ledger" || strings.HasPrefix(path, "/ledger/"):
+		if write {
+			return granted[ScopeLedgersWrite]
+		}
+		return granted[ScopeRead]
+	case path == "/user/:uuid":
+		return !write && granted[ScopeRead]
+	}
+
+	// Account management is only available to session tokens
+	return false
+}
+
+// Ledgers an API token is restricted to, ok is false when the caller can access all its ledgers
+func AllowedLedgers(c *gin.Context) (map[string]bool, bool) {
+	ledgers, ok := c.Get("LedgerScopes")
+	if !ok {
+		return nil, false
+	}
+	return ledgers.(map[string]bool), true
+}
+
+// Authenticate a request carrying an API token
+func validateAPIToken(c *gin.Context, token string) {
+	ATID, UUID, scopes, err := lookupAPIToken(token)
+	if err != nil {
+		r := response.New()
+		r.Message = err.Error()
+		if r.Message == "invalid API token" || r.Message == "API token has been revoked" || r.Message == "API token is expired" {
+			c.JSON(http.StatusUnauthorized, r)
+		} else {
+			c.JSON(http.StatusInternalServerError, r)
+		}
+		c.Abort()
+		return
+	}
+
+	if !scopesAllow(c, scopes) {
+		r := response.New()
+		r.Message = "API token scopes do not allow this request"
+		logger.Warn("[AUTH] API token: " + ATID + " not allowed for " + c.Request.Method + " " + c.FullPath())
+		c.JSON(http.StatusForbidden, r)
+		c.Abort()
+		return
+	}
+
+	c.Set("UUID", UUID)
+	c.Set("ATID", ATID)
+	c.Next()
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	allowedLedger = "0a0a0a0a-0000-4000-8000-00000000000a"
+	otherLedger   = "0b0b0b0b-0000-4000-8000-00000000000b"
+)
+
+// Whether scopesAllow lets a token with the scopes through to the route
+func allowed(scopes []string, method, route, path string) bool {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	result := false
+	router.Handle(method, route, func(c *gin.Context) { result = scopesAllow(c, scopes) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	return result
+}
+
+func TestScopesAllowLedgerRestrictedToken(t *testing.T) {
+	scopes := []string{ScopeRead, ScopeLedgersWrite, ScopeTransactionsWrite, scopeLedgerPrefix + allowedLedger}
+
+	tests := []struct {
+		name   string
+		method string
+		route  string
+		path   string
+		want   bool
+	}{
+		{"read the listed ledger", http.MethodGet, "/ledger/:ulid/types", "/ledger/" + allowedLedger + "/types", true},
+		{"change the listed ledger", http.MethodPatch, "/ledger/:ulid/", "/ledger/" + allowedLedger + "/", true},
+		{"create a transaction in the listed ledger", http.MethodPost, "/ledger/:ulid/transaction", "/ledger/" + allowedLedger + "/transaction", true},
+		{"read another ledger", http.MethodGet, "/ledger/:ulid/types", "/ledger/" + otherLedger + "/types", false},
+		{"change another ledger", http.MethodPost, "/ledger/:ulid/transaction", "/ledger/" + otherLedger + "/transaction", false},
+		{"list ledgers", http.MethodGet, "/ledger", "/ledger", true},
+		{"list templates", http.MethodGet, "/ledger/template", "/ledger/template", true},
+		{"create a ledger", http.MethodPost, "/ledger", "/ledger", false},
+		{"delete a template", http.MethodDelete, "/ledger/template/:tpid", "/ledger/template/1", false},
+	}
+	for _, tt := range tests {
+		if got := allowed(scopes, tt.method, tt.route, tt.path); got != tt.want {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScopesAllowUnrestrictedToken(t *testing.T) {
+	scopes := []string{ScopeRead, ScopeLedgersWrite}
+
+	if !allowed(scopes, http.MethodDelete, "/ledger/template/:tpid", "/ledger/template/1") {
+		t.Error("unrestricted token with ledgers:write can not delete a template")
+	}
+	if !allowed(scopes, http.MethodPost, "/ledger", "/ledger") {
+		t.Error("unrestricted token with ledgers:write can not create a ledger")
+	}
+	if allowed([]string{ScopeRead}, http.MethodDelete, "/ledger/template/:tpid", "/ledger/template/1") {
+		t.Error("read only token can delete a template")
+	}
+}
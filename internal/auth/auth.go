@@ -4,6 +4,7 @@ import (
 	"Fortune_Tracker_API/config"
 	"Fortune_Tracker_API/internal/response"
 	"Fortune_Tracker_API/pkg/logger"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -12,38 +13,86 @@ import (
 	"github.com/golang-jwt/jwt"
 )
 
-var jwtSecretKey []byte
-
 type authClaims struct {
 	UUID string `json:"UUID"`
+	// Set on tokens which are not access tokens (e.g. two-factor challenges)
+	Purpose string `json:"Purpose,omitempty"`
 	jwt.StandardClaims
 }
 
-func SetJWTKey() {
-	jwtSecretKey = []byte(config.Viper.GetString("JWT_SECRET_KEY"))
+const challengePurpose = "2fa-challenge"
+
+// Lifetime of access tokens, ACCESS_TOKEN_TTL in config (default 15 minutes)
+func accessTokenTTL() time.Duration {
+	if ttl := config.Viper.GetDuration("ACCESS_TOKEN_TTL"); ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
 }
 
-func GenerateToken(UUID, email string) (string, error) {
+// Generate a short-lived access token bound to the session SID (jti claim)
+func GenerateToken(UUID, email, SID string) (string, int64, error) {
 	// Set JWT claims fields
-	expiresAt := time.Now().Add(30 * 24 * time.Hour).Unix()	// 30 * 24 hours
-    token := jwt.NewWithClaims(jwt.SigningMethodHS512, authClaims{
-        UUID: UUID,
+	expiresAt := time.Now().Add(accessTokenTTL()).Unix()
+	claims := authClaims{
+		UUID: UUID,
 		StandardClaims: jwt.StandardClaims{
-            Subject:   email,
-            ExpiresAt: expiresAt,
-        },
-    })
-
-	// Sign the token with our secret key
-    tokenString, err := token.SignedString(jwtSecretKey)
-    if err != nil {
+			Id:        SID,
+			Subject:   email,
+			ExpiresAt: expiresAt,
+		},
+	}
+
+	// Sign the token with the active key
+	tokenString, err := signToken(claims)
+	if err != nil {
 		logger.Error("[AUTH] Failed to generate token: " + err.Error())
-        return "", err
-    }
+		return "", 0, err
+	}
 
 	logger.Info("[AUTH] Generated token for user: " + email)
 
-    return tokenString, nil
+	return tokenString, expiresAt, nil
+}
+
+// Generate a short-lived token proving the password step of a two-factor login succeeded
+func GenerateChallengeToken(UUID, email string) (string, int64, error) {
+	expiresAt := time.Now().Add(5 * time.Minute).Unix()
+	claims := authClaims{
+		UUID:    UUID,
+		Purpose: challengePurpose,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   email,
+			ExpiresAt: expiresAt,
+		},
+	}
+
+	tokenString, err := signToken(claims)
+	if err != nil {
+		logger.Error("[AUTH] Failed to generate challenge token: " + err.Error())
+		return "", 0, err
+	}
+
+	logger.Info("[AUTH] Generated two-factor challenge for user: " + email)
+
+	return tokenString, expiresAt, nil
+}
+
+// Parse a two-factor challenge token, returns the UUID and email it was issued for
+func ParseChallengeToken(token string) (string, string, error) {
+	tokenClaims, err := jwt.ParseWithClaims(token, &authClaims{}, verificationKey)
+	if err != nil {
+		logger.Warn("[AUTH] Invalid challenge token: " + err.Error())
+		return "", "", errors.New("invalid or expired challenge token")
+	}
+
+	claims, ok := tokenClaims.Claims.(*authClaims)
+	if !ok || !tokenClaims.Valid || claims.Purpose != challengePurpose {
+		logger.Warn("[AUTH] Token is not a challenge token")
+		return "", "", errors.New("invalid or expired challenge token")
+	}
+
+	return claims.UUID, claims.Subject, nil
 }
 
 func ValidateToken(c *gin.Context) {
@@ -57,23 +106,35 @@ func ValidateToken(c *gin.Context) {
 		c.Abort()
 		return
 	}
-    token := strings.Split(auth, "Bearer ")[1]
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		r := response.New()
+		r.Message = "Authorization header is not a Bearer token"
+		logger.Warn("[AUTH] Received request without Bearer authorization header")
+		c.JSON(http.StatusUnauthorized, r)
+		c.Abort()
+		return
+	}
+
+	// Personal API tokens are opaque, everything else is a session JWT
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		validateAPIToken(c, token)
+		return
+	}
 
 	// Parse token
-	tokenClaims, err := jwt.ParseWithClaims(token, &authClaims{}, func(token *jwt.Token) (i interface{}, err error) {
-		return jwtSecretKey, nil
-	})
+	tokenClaims, err := jwt.ParseWithClaims(token, &authClaims{}, verificationKey)
 	// Check for token validation errors
 	if err != nil {
 		var r = response.New()
 		if ve, ok := err.(*jwt.ValidationError); ok {
-			if ve.Errors & jwt.ValidationErrorMalformed != 0 {
+			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
 				r.Message = "token is not correctly formatted as a JWT (missing or invalid segments)"
-			} else if ve.Errors & jwt.ValidationErrorUnverifiable != 0{
+			} else if ve.Errors&jwt.ValidationErrorUnverifiable != 0 {
 				r.Message = "token cannot be verified due to problems with the token's signature"
-			} else if ve.Errors & jwt.ValidationErrorSignatureInvalid != 0 {
+			} else if ve.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
 				r.Message = "signature validation failed (token's content has been tampered with)"
-			} else if ve.Errors & jwt.ValidationErrorExpired != 0 {
+			} else if ve.Errors&jwt.ValidationErrorExpired != 0 {
 				r.Message = "token is expired"
 			} else {
 				r.Message = "can not handle this token"
@@ -84,12 +145,50 @@ func ValidateToken(c *gin.Context) {
 		return
 	}
 
-	// Check if token is valid -> continue
-	if claims, ok := tokenClaims.Claims.(*authClaims); ok && tokenClaims.Valid {
-		c.Set("UUID", claims.UUID)
-		c.Next()
-	} else {
+	// Check if token is valid
+	claims, ok := tokenClaims.Claims.(*authClaims)
+	if !ok || !tokenClaims.Valid {
+		c.Abort()
+		return
+	}
+
+	// Only access tokens are accepted
+	if claims.Purpose != "" {
+		r := response.New()
+		r.Message = "token is not an access token"
+		logger.Warn("[AUTH] Received " + claims.Purpose + " token as access token")
+		c.JSON(http.StatusUnauthorized, r)
 		c.Abort()
 		return
 	}
+
+	// Check the session of the token has not been revoked -> continue
+	active, err := isSessionActive(claims.Id)
+	if err != nil {
+		r := response.New()
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		c.Abort()
+		return
+	} else if !active {
+		r := response.New()
+		r.Message = "session has been revoked"
+		logger.Warn("[AUTH] Received token of revoked session: " + claims.Id)
+		c.JSON(http.StatusUnauthorized, r)
+		c.Abort()
+		return
+	}
+
+	// Keep track of when and where the session was last used
+	if err = touchSession(claims.Id, c.ClientIP()); err != nil {
+		r := response.New()
+		r.Message = err.Error()
+		c.JSON(http.StatusInternalServerError, r)
+		c.Abort()
+		return
+	}
+
+	c.Set("UUID", claims.UUID)
+	c.Set("SID", claims.Id)
+	c.Next()
 }
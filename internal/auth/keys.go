@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/pkg/logger"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// A JWT key identified by its kid (the PEM file name without extension).
+// Keys without a private part (retired keys) can only verify tokens.
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+var jwtKeys map[string]*jwtKey
+var signingKey *jwtKey
+
+// Load every PEM key of JWT_KEY_DIR and sign with JWT_ACTIVE_KID.
+// Rotate by adding the new key, publishing it in the JWKS, then switching JWT_ACTIVE_KID;
+// the previous key stays in the directory until the tokens it signed have expired.
+func LoadJWTKeys() error {
+	dir := config.Viper.GetString("JWT_KEY_DIR")
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*jwtKey)
+	for _, file := range files {
+		key, err := loadJWTKey(file)
+		if err != nil {
+			return fmt.Errorf("loading JWT key %s: %w", file, err)
+		}
+		keys[key.kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("no JWT keys found in JWT_KEY_DIR: " + dir)
+	}
+
+	// Pick the signing key, the only key can be used without JWT_ACTIVE_KID
+	kid := config.Viper.GetString("JWT_ACTIVE_KID")
+	if kid == "" && len(keys) == 1 {
+		for k := range keys {
+			kid = k
+		}
+	}
+	active, ok := keys[kid]
+	if !ok {
+		return errors.New("JWT_ACTIVE_KID does not match any JWT key: " + kid)
+	} else if active.private == nil {
+		return errors.New("JWT_ACTIVE_KID has no private key: " + kid)
+	}
+
+	jwtKeys = keys
+	signingKey = active
+	logger.Info(fmt.Sprintf("[AUTH] Loaded %d JWT keys, signing with: %s (%s)", len(keys), kid, active.method.Alg()))
+	return nil
+}
+
+func loadJWTKey(file string) (*jwtKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	key := &jwtKey{kid: strings.TrimSuffix(filepath.Base(file), ".pem")}
+	switch block.Type {
+	case "PRIVATE KEY":
+		if key.private, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case "RSA PRIVATE KEY":
+		if key.private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case "PUBLIC KEY":
+		if key.public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported PEM block: " + block.Type)
+	}
+
+	if key.private != nil {
+		key.public = key.private.(crypto.Signer).Public()
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys should be at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	return key, nil
+}
+
+// Sign claims with the active key, the kid header tells verifiers which key to use
+func signToken(claims jwt.Claims) (string, error) {
+	if signingKey == nil {
+		return "", errors.New("JWT keys are not loaded")
+	}
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.kid
+	return token.SignedString(signingKey.private)
+}
+
+// Find the verification key of a token by its kid, the algorithm has to match the key
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jwtKeys[kid]
+	if !ok {
+		return nil, errors.New("unknown kid: " + kid)
+	} else if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method: " + token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Serve the public keys as a JWK set (RFC 7517) so other services can verify tokens
+func JWKS(c *gin.Context) {
+	kids := make([]string, 0, len(jwtKeys))
+	for kid := range jwtKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]jwk, 0, len(kids))
+	for _, kid := range kids {
+		key := jwtKeys[kid]
+		k := jwk{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			k.Kty = "RSA"
+			k.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			k.Kty = "OKP"
+			k.Crv = "Ed25519"
+			k.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, k)
+	}
+
+	// JWKS clients expect the plain key set, not the API response format
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/pkg/logger"
+	"Fortune_Tracker_API/pkg/mariadb"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    int64
+}
+
+// Client a session is used from
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+type Session struct {
+	SID        string `json:"SID"`
+	DeviceName string `json:"DeviceName"`
+	UserAgent  string `json:"UserAgent"`
+	IP         string `json:"IP"`
+	CreatedAt  int64  `json:"CreatedAt"`
+	LastSeenAt int64  `json:"LastSeenAt"`
+	Current    bool   `json:"Current"`
+}
+
+// Lifetime of refresh tokens, REFRESH_TOKEN_TTL in config (default 30 days)
+func refreshTokenTTL() time.Duration {
+	if ttl := config.Viper.GetDuration("REFRESH_TOKEN_TTL"); ttl > 0 {
+		return ttl
+	}
+	return 30 * 24 * time.Hour
+}
+
+// Generate a random opaque token and the hash to store in the database
+func NewOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// Hash an opaque token, only the hash is ever stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create a new session for the user and issue its first token pair
+func NewSession(UUID, email string, device Device) (TokenPair, error) {
+	SID := uuid.NewString()
+	now := time.Now().Unix()
+
+	query := "INSERT INTO Session (SID, UUID, Device_Name, User_Agent, IP, Created_At, Last_Seen_At) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := mariadb.DB.Exec(query, SID, UUID, truncate(device.Name, 255), truncate(device.UserAgent, 512), device.IP, now, now); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+
+	logger.Info("[AUTH] Created session: " + SID + " for user: " + UUID)
+
+	return issueTokenPair(UUID, email, SID)
+}
+
+// Rotate a refresh token: the presented token is consumed and a new pair is issued.
+// Presenting an already consumed token revokes the whole session.
+func Refresh(refreshToken string, device Device) (TokenPair, error) {
+	var SID, UUID, email string
+	var expiresAt int64
+	var usedAt sql.NullInt64
+	hash := HashToken(refreshToken)
+
+	// Find the refresh token
+	query := "SELECT SID, UUID, Expires_At, Used_At FROM Refresh_Token WHERE Token_Hash = ?"
+	err := mariadb.DB.QueryRow(query, hash).Scan(&SID, &UUID, &expiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logger.Warn("[AUTH] Refresh token not found")
+			return TokenPair{}, errors.New("invalid refresh token")
+		}
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+
+	// A consumed token is presented again -> the token family is compromised
+	if usedAt.Valid {
+		logger.Warn("[AUTH] Refresh token reuse detected for session: " + SID)
+		if err = RevokeSession(SID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, errors.New("refresh token reuse detected")
+	}
+
+	if expiresAt < time.Now().Unix() {
+		logger.Warn("[AUTH] Refresh token expired for session: " + SID)
+		return TokenPair{}, errors.New("refresh token is expired")
+	}
+
+	// Check the session has not been revoked
+	if active, err := isSessionActive(SID); err != nil {
+		return TokenPair{}, err
+	} else if !active {
+		logger.Warn("[AUTH] Refresh token of revoked session: " + SID)
+		return TokenPair{}, errors.New("session has been revoked")
+	}
+
+	// Consume the token, a concurrent refresh with the same token counts as reuse
+	query = "UPDATE Refresh_Token SET Used_At = ? WHERE Token_Hash = ? AND Used_At IS NULL"
+	result, err := mariadb.DB.Exec(query, time.Now().Unix(), hash)
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+	if rowsaffected, err := result.RowsAffected(); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	} else if rowsaffected == 0 {
+		logger.Warn("[AUTH] Refresh token reuse detected for session: " + SID)
+		if err = RevokeSession(SID); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, errors.New("refresh token reuse detected")
+	}
+
+	// Record where the session is used from
+	query = "UPDATE Session SET User_Agent = ?, IP = ?, Last_Seen_At = ? WHERE SID = ?"
+	if _, err = mariadb.DB.Exec(query, truncate(device.UserAgent, 512), device.IP, time.Now().Unix(), SID); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+
+	// Get user email for the token subject
+	query = "SELECT Email FROM User WHERE UUID = ?"
+	if err = mariadb.DB.QueryRow(query, UUID).Scan(&email); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+
+	logger.Info("[AUTH] Rotated refresh token for session: " + SID)
+
+	return issueTokenPair(UUID, email, SID)
+}
+
+// Revoke a session, its access and refresh tokens stop working immediately
+func RevokeSession(SID string) error {
+	query := "UPDATE Session SET Revoked_At = ? WHERE SID = ? AND Revoked_At IS NULL"
+	if _, err := mariadb.DB.Exec(query, time.Now().Unix(), SID); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	}
+
+	logger.Info("[AUTH] Revoked session: " + SID)
+	return nil
+}
+
+// Revoke every session of the user except the one given (pass "" to revoke all)
+func RevokeAllSessions(UUID, exceptSID string) error {
+	query := "UPDATE Session SET Revoked_At = ? WHERE UUID = ? AND SID != ? AND Revoked_At IS NULL"
+	result, err := mariadb.DB.Exec(query, time.Now().Unix(), UUID, exceptSID)
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	}
+
+	rowsaffected, _ := result.RowsAffected()
+	logger.Info(fmt.Sprintf("[AUTH] Revoked %d sessions of user: %s", rowsaffected, UUID))
+	return nil
+}
+
+// List the sessions of the user still in use (not revoked, refreshed within the refresh token lifetime)
+func ListSessions(UUID, currentSID string) ([]Session, error) {
+	sessions := []Session{}
+
+	query := `SELECT SID, Device_Name, User_Agent, IP, Created_At, Last_Seen_At FROM Session
+		WHERE UUID = ? AND Revoked_At IS NULL AND Last_Seen_At > ? ORDER BY Last_Seen_At DESC`
+	rows, err := mariadb.DB.Query(query, UUID, time.Now().Add(-refreshTokenTTL()).Unix())
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session Session
+		if err = rows.Scan(&session.SID, &session.DeviceName, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			logger.Error("[AUTH] " + err.Error())
+			return sessions, err
+		}
+		session.Current = session.SID == currentSID
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return sessions, err
+	}
+
+	return sessions, nil
+}
+
+// Revoke a session of the user, e.g. the one of a lost device
+func RevokeUserSession(UUID, SID string) error {
+	query := "UPDATE Session SET Revoked_At = ? WHERE SID = ? AND UUID = ? AND Revoked_At IS NULL"
+	result, err := mariadb.DB.Exec(query, time.Now().Unix(), SID, UUID)
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	}
+
+	if rowsaffected, err := result.RowsAffected(); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	} else if rowsaffected == 0 {
+		logger.Warn("[AUTH] Session: " + SID + " not found for user: " + UUID)
+		return errors.New("session not found")
+	}
+
+	logger.Info("[AUTH] Revoked session: " + SID)
+	return nil
+}
+
+// Update the last seen time and IP of a session, at most once a minute
+func touchSession(SID, IP string) error {
+	now := time.Now().Unix()
+	query := "UPDATE Session SET Last_Seen_At = ?, IP = ? WHERE SID = ? AND Last_Seen_At < ?"
+	if _, err := mariadb.DB.Exec(query, now, IP, SID, now-60); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// Cut s to at most max characters, VARCHAR lengths count characters and not bytes
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) > max {
+		return string([]rune(s)[:max])
+	}
+	return s
+}
+
+func issueTokenPair(UUID, email, SID string) (TokenPair, error) {
+	var pair TokenPair
+	var hash string
+	var err error
+
+	// Generate access token
+	if pair.AccessToken, pair.ExpiresAt, err = GenerateToken(UUID, email, SID); err != nil {
+		return TokenPair{}, err
+	}
+
+	// Generate refresh token and store its hash
+	if pair.RefreshToken, hash, err = NewOpaqueToken(); err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+
+	query := "INSERT INTO Refresh_Token (Token_Hash, SID, UUID, Expires_At) VALUES (?, ?, ?, ?)"
+	_, err = mariadb.DB.Exec(query, hash, SID, UUID, time.Now().Add(refreshTokenTTL()).Unix())
+	if err != nil {
+		logger.Error("[AUTH] " + err.Error())
+		return TokenPair{}, err
+	}
+
+	return pair, nil
+}
+
+func isSessionActive(SID string) (bool, error) {
+	var revokedAt sql.NullInt64
+
+	query := "SELECT Revoked_At FROM Session WHERE SID = ?"
+	err := mariadb.DB.QueryRow(query, SID).Scan(&revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		logger.Error("[AUTH] " + err.Error())
+		return false, err
+	}
+
+	return !revokedAt.Valid, nil
+}
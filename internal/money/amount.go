@@ -0,0 +1,323 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Limits of amounts given by clients, a single amount is below 10^18 units of 10^-6 and fits in int64.
+// Sums of such amounts can overflow, Add and Sub report it.
+const (
+	maxScale         = 6
+	maxIntegerDigits = 12
+)
+
+var errOverflow = errors.New("amount is too large")
+
+var pow10 = [...]int64{
+	1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000,
+	10000000000, 100000000000, 1000000000000, 10000000000000, 100000000000000,
+	1000000000000000, 10000000000000000, 100000000000000000, 1000000000000000000,
+}
+
+// An exact decimal amount of money: units / 10^scale.
+// Stored as Decimal128 in MongoDB, read from JSON strings or numbers and written as JSON numbers.
+// The zero value is 0.
+type Amount struct {
+	units int64
+	scale int
+}
+
+// Build an amount with trailing decimal zeros removed, so equal amounts have equal fields
+func newAmount(units int64, scale int) Amount {
+	for scale > 0 && units%10 == 0 {
+		units /= 10
+		scale--
+	}
+	return Amount{units: units, scale: scale}
+}
+
+// Amount of the given number of minor units of a currency with that many decimal digits
+func FromUnits(units int64, digits int) Amount {
+	return newAmount(units, digits)
+}
+
+// Parse a decimal amount such as "12", "-0.5" or "1.25e2"
+func Parse(s string) (Amount, error) {
+	invalid := errors.New("invalid amount: " + s)
+
+	// Split off the exponent
+	mantissa, exponent := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		var err error
+		mantissa = s[:i]
+		if exponent, err = strconv.Atoi(s[i+1:]); err != nil || exponent > 100 || exponent < -100 {
+			return Amount{}, invalid
+		}
+	}
+
+	negative := false
+	if strings.HasPrefix(mantissa, "-") {
+		negative, mantissa = true, mantissa[1:]
+	} else if strings.HasPrefix(mantissa, "+") {
+		mantissa = mantissa[1:]
+	}
+
+	integer, fraction, _ := strings.Cut(mantissa, ".")
+	if integer == "" && fraction == "" {
+		return Amount{}, invalid
+	}
+	for _, r := range integer + fraction {
+		if r < '0' || r > '9' {
+			return Amount{}, invalid
+		}
+	}
+
+	// Move the decimal point by the exponent
+	digits, scale := integer+fraction, len(fraction)-exponent
+	if scale < 0 {
+		digits += strings.Repeat("0", -scale)
+		scale = 0
+	}
+	digits = strings.TrimLeft(digits, "0")
+	for scale > 0 && strings.HasSuffix(digits, "0") {
+		digits = digits[:len(digits)-1]
+		scale--
+	}
+	if digits == "" {
+		// Zero has no significant decimals
+		scale = 0
+	}
+
+	if scale > maxScale {
+		return Amount{}, errors.New("amount has more than " + strconv.Itoa(maxScale) + " decimals")
+	} else if len(digits)-scale > maxIntegerDigits {
+		return Amount{}, errors.New("amount is too large")
+	}
+
+	var units int64
+	if digits != "" {
+		units, _ = strconv.ParseInt(digits, 10, 64)
+	}
+	if negative {
+		units = -units
+	}
+	return newAmount(units, scale), nil
+}
+
+func (a Amount) String() string {
+	s := strconv.FormatInt(a.units, 10)
+	if a.scale == 0 {
+		return s
+	}
+
+	sign := ""
+	if a.units < 0 {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= a.scale {
+		s = strings.Repeat("0", a.scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-a.scale] + "." + s[len(s)-a.scale:]
+}
+
+// Number of decimal digits, without trailing zeros
+func (a Amount) Decimals() int {
+	return a.scale
+}
+
+func (a Amount) Sign() int {
+	switch {
+	case a.units > 0:
+		return 1
+	case a.units < 0:
+		return -1
+	}
+	return 0
+}
+
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{units: -a.units, scale: a.scale}
+}
+
+// units * 10^exp, false when it does not fit in int64
+func scaleUnits(units int64, exp int) (int64, bool) {
+	if exp == 0 {
+		return units, true
+	}
+	if units > math.MaxInt64/pow10[exp] || units < math.MinInt64/pow10[exp] {
+		return 0, false
+	}
+	return units * pow10[exp], true
+}
+
+// Both amounts in units of the larger scale, false when one of them does not fit in int64
+func align(a, b Amount) (int64, int64, int, bool) {
+	if a.scale < b.scale {
+		x, ok := scaleUnits(a.units, b.scale-a.scale)
+		return x, b.units, b.scale, ok
+	}
+	y, ok := scaleUnits(b.units, a.scale-b.scale)
+	return a.units, y, a.scale, ok
+}
+
+// Sum of two amounts, an error when it does not fit
+func (a Amount) Add(b Amount) (Amount, error) {
+	x, y, scale, ok := align(a, b)
+	if !ok || (y > 0 && x > math.MaxInt64-y) || (y < 0 && x < math.MinInt64-y) {
+		return Amount{}, errOverflow
+	}
+	return newAmount(x+y, scale), nil
+}
+
+// Difference of two amounts, an error when it does not fit
+func (a Amount) Sub(b Amount) (Amount, error) {
+	return a.Add(b.Neg())
+}
+
+// Compare two amounts, -1 if a < b, 0 if equal and 1 if a > b
+func (a Amount) Cmp(b Amount) int {
+	x, y, _, ok := align(a, b)
+	if !ok {
+		// Too far apart to align, compare exactly
+		return a.rat().Cmp(b.rat())
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func (a Amount) rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(a.units), big.NewInt(pow10[a.scale]))
+}
+
+// Amount in minor units of a currency with that many decimal digits, rounded half away from zero
+func (a Amount) Units(digits int) int64 {
+	if digits >= a.scale {
+		return a.units * pow10[digits-a.scale]
+	}
+
+	div := pow10[a.scale-digits]
+	q, r := a.units/div, a.units%div
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= div {
+		if a.units < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Accept a JSON number or a string holding one, the digits are read exactly
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = strings.TrimSpace(unquoted)
+	}
+
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	d, ok := primitive.ParseDecimal128FromBigInt(big.NewInt(a.units), -a.scale)
+	if !ok {
+		return 0, nil, errors.New("amount can not be stored as Decimal128: " + a.String())
+	}
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, d), nil
+}
+
+// Read a Decimal128, or a double / integer written before amounts were exact.
+// Doubles are read as their shortest decimal representation (0.1 is 0.1).
+func (a *Amount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	var s string
+	switch t {
+	case bsontype.Decimal128:
+		d, _, ok := bsoncore.ReadDecimal128(data)
+		if !ok {
+			return errors.New("invalid Decimal128 amount")
+		}
+		s = d.String()
+	case bsontype.Double:
+		f, _, ok := bsoncore.ReadDouble(data)
+		if !ok {
+			return errors.New("invalid double amount")
+		}
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	case bsontype.Int32:
+		i, _, ok := bsoncore.ReadInt32(data)
+		if !ok {
+			return errors.New("invalid int32 amount")
+		}
+		s = strconv.FormatInt(int64(i), 10)
+	case bsontype.Int64:
+		i, _, ok := bsoncore.ReadInt64(data)
+		if !ok {
+			return errors.New("invalid int64 amount")
+		}
+		s = strconv.FormatInt(i, 10)
+	case bsontype.Null:
+		*a = Amount{}
+		return nil
+	default:
+		return errors.New("can not read amount from BSON " + t.String())
+	}
+
+	amount, err := parseStored(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// Parse an amount read from the database, sums and old doubles may carry more decimals
+// than clients can send and are rounded to the supported precision
+func parseStored(s string) (Amount, error) {
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Amount{}, errors.New("invalid stored amount: " + s)
+	}
+
+	// Round half away from zero to maxScale decimals
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt64(pow10[maxScale]))
+	num, den := scaled.Num(), scaled.Denom()
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	if !q.IsInt64() {
+		return Amount{}, errors.New("stored amount is too large: " + s)
+	}
+	return newAmount(q.Int64(), maxScale), nil
+}
@@ -0,0 +1,258 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Document holding an amount, as transactions do
+type document struct {
+	Amount Amount `bson:"Amount"`
+}
+
+func mustParse(t *testing.T, s string) Amount {
+	t.Helper()
+	a, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return a
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"0", "0"},
+		{"12", "12"},
+		{"0.1", "0.1"},
+		{"-0.5", "-0.5"},
+		{"+1.50", "1.5"},
+		{"-0", "0"},
+		{"00012.3400", "12.34"},
+		{".5", "0.5"},
+		{"5.", "5"},
+		{"1.25e2", "125"},
+		{"1.25E+2", "125"},
+		{"-1.5e-3", "-0.0015"},
+		{"125e-5", "0.00125"},
+		{"0e50", "0"},
+		{"0.0000000", "0"},
+		{"-0.00000000", "0"},
+		{"0e-10", "0"},
+		{"1.0000000", "1"},
+		{"0.000001", "0.000001"},
+		{"999999999999.999999", "999999999999.999999"},
+		{"-999999999999.999999", "-999999999999.999999"},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in).String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejected(t *testing.T) {
+	for _, in := range []string{
+		"", "-", "+", ".", "e2", "abc", "1.2.3", "--1", "+-1", "1,5", " 1", "1 ", "0x10", "NaN", "Inf",
+		"1e", "1e+", "1e1.5", "1e101",
+		// More decimals than supported
+		"0.0000001", "1e-7", "1.0000005",
+		// More integer digits than supported
+		"1000000000000", "1e12", "-1000000000000.5",
+	} {
+		if a, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %s, want an error", in, a)
+		}
+	}
+}
+
+func TestAddIsExact(t *testing.T) {
+	sum, err := mustParse(t, "0.1").Add(mustParse(t, "0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != mustParse(t, "0.3") || sum.Cmp(mustParse(t, "0.3")) != 0 || sum.String() != "0.3" {
+		t.Fatalf("0.1 + 0.2 = %s, want 0.3", sum)
+	}
+
+	tests := []struct {
+		a, b, sum, diff string
+	}{
+		{"1.5", "2.25", "3.75", "-0.75"},
+		{"10", "0.01", "10.01", "9.99"},
+		{"-1.1", "1.1", "0", "-2.2"},
+		{"0.000001", "999999", "999999.000001", "-999998.999999"},
+	}
+	for _, tt := range tests {
+		a, b := mustParse(t, tt.a), mustParse(t, tt.b)
+		if got, err := a.Add(b); err != nil || got.String() != tt.sum {
+			t.Errorf("%s + %s = %s, %v, want %s", tt.a, tt.b, got, err, tt.sum)
+		}
+		if got, err := a.Sub(b); err != nil || got.String() != tt.diff {
+			t.Errorf("%s - %s = %s, %v, want %s", tt.a, tt.b, got, err, tt.diff)
+		}
+	}
+}
+
+func TestAddOverflow(t *testing.T) {
+	max := mustParse(t, "999999999999.999999")
+
+	// About 10^18 units each, 9 of them still fit in int64
+	sum := max
+	for i := 2; i <= 9; i++ {
+		var err error
+		if sum, err = sum.Add(max); err != nil {
+			t.Fatalf("sum of %d largest amounts: %v", i, err)
+		}
+	}
+	if _, err := sum.Add(max); err == nil {
+		t.Fatal("sum of 10 largest amounts did not report an overflow")
+	}
+	if _, err := sum.Neg().Sub(max); err == nil {
+		t.Fatal("difference below the int64 range did not report an overflow")
+	}
+
+	// Aligning a large integer amount to 6 decimals overflows too
+	large := Amount{units: math.MaxInt64 / 10}
+	if _, err := large.Add(mustParse(t, "0.000001")); err == nil {
+		t.Fatal("sum with an amount too large to align did not report an overflow")
+	}
+}
+
+func TestCmp(t *testing.T) {
+	tests := []struct {
+		a, b Amount
+		want int
+	}{
+		{mustParse(t, "1.10"), mustParse(t, "1.1"), 0},
+		{mustParse(t, "1.09"), mustParse(t, "1.1"), -1},
+		{mustParse(t, "-1"), mustParse(t, "-1.000001"), 1},
+		// Too far apart to align in int64
+		{Amount{units: math.MaxInt64 / 10}, mustParse(t, "0.000001"), 1},
+		{Amount{units: math.MinInt64 / 10}, mustParse(t, "0.000001"), -1},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Cmp(tt.b); got != tt.want {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		in     string
+		digits int
+		want   int64
+	}{
+		{"12", 2, 1200},
+		{"1.5", 3, 1500},
+		{"2.344", 2, 234},
+		{"2.345", 2, 235},
+		{"-2.345", 2, -235},
+		{"-2.344", 2, -234},
+		{"0.005", 2, 1},
+		{"-0.005", 2, -1},
+		{"0.004999", 2, 0},
+		{"2.5", 0, 3},
+		{"-2.5", 0, -3},
+		{"1.4999", 0, 1},
+		{"0.000001", 6, 1},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in).Units(tt.digits); got != tt.want {
+			t.Errorf("Units(%s, %d) = %d, want %d", tt.in, tt.digits, got, tt.want)
+		}
+	}
+
+	if got := FromUnits(-1234, 2); got.String() != "-12.34" || got.Units(2) != -1234 {
+		t.Errorf("FromUnits(-1234, 2) = %s", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct{ Amount Amount }
+	for _, in := range []string{`{"Amount": 0.3}`, `{"Amount": "0.3"}`, `{"Amount": " 0.30 "}`, `{"Amount": 3e-1}`} {
+		if err := json.Unmarshal([]byte(in), &v); err != nil || v.Amount.String() != "0.3" {
+			t.Errorf("Unmarshal(%s) = %s, %v, want 0.3", in, v.Amount, err)
+		}
+	}
+	for _, in := range []string{`{"Amount": "abc"}`, `{"Amount": 0.0000001}`, `{"Amount": true}`} {
+		if err := json.Unmarshal([]byte(in), &v); err == nil {
+			t.Errorf("Unmarshal(%s) = %s, want an error", in, v.Amount)
+		}
+	}
+
+	v.Amount = mustParse(t, "-12.5")
+	if b, err := json.Marshal(v); err != nil || string(b) != `{"Amount":-12.5}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+}
+
+func TestBSONRoundTrip(t *testing.T) {
+	for _, in := range []string{"0", "0.3", "-12.34", "0.000001", "999999999999.999999"} {
+		b, err := bson.Marshal(document{mustParse(t, in)})
+		if err != nil {
+			t.Fatalf("Marshal(%s): %v", in, err)
+		}
+		if typ := bson.Raw(b).Lookup("Amount").Type; typ != bson.TypeDecimal128 {
+			t.Fatalf("%s stored as %s, want Decimal128", in, typ)
+		}
+
+		var out document
+		if err = bson.Unmarshal(b, &out); err != nil || out.Amount != mustParse(t, in) {
+			t.Errorf("round trip of %s = %s, %v", in, out.Amount, err)
+		}
+	}
+}
+
+func TestBSONStored(t *testing.T) {
+	decimal := func(s string) primitive.Decimal128 {
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		stored interface{}
+		want   string
+	}{
+		{"decimal", decimal("12.34"), "12.34"},
+		{"decimal sum with more decimals", decimal("1.23456789"), "1.234568"},
+		{"negative decimal rounded away from zero", decimal("-0.0000005"), "-0.000001"},
+		{"decimal with exponent", decimal("1.5E+3"), "1500"},
+		{"double", 0.1, "0.1"},
+		{"double sum", 0.30000000000000004, "0.3"},
+		{"double division", 100.0 / 3, "33.333333"},
+		{"negative double", -2.5, "-2.5"},
+		{"int32", int32(42), "42"},
+		{"int64", int64(1000000000000), "1000000000000"},
+		{"null", nil, "0"},
+	}
+	for _, tt := range tests {
+		b, err := bson.Marshal(bson.M{"Amount": tt.stored})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		out := document{mustParse(t, "7")}
+		if err = bson.Unmarshal(b, &out); err != nil || out.Amount.String() != tt.want {
+			t.Errorf("%s: read %s, %v, want %s", tt.name, out.Amount, err, tt.want)
+		}
+	}
+
+	for _, stored := range []interface{}{"12", true, decimal("1E+20")} {
+		b, _ := bson.Marshal(bson.M{"Amount": stored})
+		var out document
+		if err := bson.Unmarshal(b, &out); err == nil {
+			t.Errorf("read %v as %s, want an error", stored, out.Amount)
+		}
+	}
+}
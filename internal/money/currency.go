@@ -0,0 +1,41 @@
+package money
+
+import "strings"
+
+// Currencies whose minor unit is not 2 digits (ISO 4217)
+var minorUnits = map[string]int{
+	"BHD": 3,
+	"BIF": 0,
+	"CLF": 4,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"PYG": 0,
+	"RWF": 0,
+	"TND": 3,
+	"UGX": 0,
+	"UYI": 0,
+	"UYW": 4,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+}
+
+// Number of decimal digits of the currency's minor unit, 2 for unknown currencies
+func MinorUnits(currency string) int {
+	if digits, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return digits
+	}
+	return 2
+}
@@ -0,0 +1,297 @@
+package oidc
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/pkg/logger"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// An OpenID Connect provider using the authorization code flow with PKCE
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	mu          sync.Mutex
+	discovery   *discoveryDocument
+	discoveryAt time.Time
+	keys        map[string]interface{}
+	keysAt      time.Time
+}
+
+// The fields of the identity the API relies on
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var Providers map[string]*Provider
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Load the providers listed in OIDC_PROVIDERS (e.g. "google,apple"),
+// each configured by OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and _SCOPES
+func LoadProviders() {
+	Providers = make(map[string]*Provider)
+	for _, name := range strings.Split(config.Viper.GetString("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		scopes := strings.Fields(config.Viper.GetString(prefix + "SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		Providers[name] = &Provider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(config.Viper.GetString(prefix+"ISSUER"), "/"),
+			ClientID:     config.Viper.GetString(prefix + "CLIENT_ID"),
+			ClientSecret: config.Viper.GetString(prefix + "CLIENT_SECRET"),
+			RedirectURL:  config.Viper.GetString(prefix + "REDIRECT_URL"),
+			Scopes:       scopes,
+		}
+		logger.Info("[OIDC] Configured provider: " + name)
+	}
+}
+
+// S256 code challenge of a PKCE code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// URL of the provider's login page
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(codeVerifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange the authorization code and return the verified identity of the ID token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Identity, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err = doJSON(req, &tokenResponse); err != nil {
+		if tokenResponse.Error != "" {
+			return Identity{}, errors.New("token endpoint error: " + tokenResponse.Error)
+		}
+		return Identity{}, err
+	} else if tokenResponse.IDToken == "" {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+
+	return p.verifyIDToken(ctx, tokenResponse.IDToken, nonce)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (Identity, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// Check signature and exp / iat / nbf
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, errors.New("unexpected signing method: " + token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(ctx, kid)
+	})
+	if err != nil {
+		return Identity{}, errors.New("invalid id_token: " + err.Error())
+	}
+
+	// Check the token was issued by the provider, for us, for this login
+	if iss, _ := claims["iss"].(string); iss != doc.Issuer {
+		return Identity{}, errors.New("id_token issuer mismatch: " + iss)
+	}
+	if !hasAudience(claims["aud"], p.ClientID) {
+		return Identity{}, errors.New("id_token audience mismatch")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return Identity{}, errors.New("id_token nonce mismatch")
+	}
+
+	var identity Identity
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	if identity.Subject == "" {
+		return Identity{}, errors.New("id_token has no subject")
+	}
+
+	return identity, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the discovery document, cached for an hour
+func (p *Provider) getDiscovery(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveryAt) < time.Hour {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc discoveryDocument
+	if err = doJSON(req, &doc); err != nil {
+		logger.Error("[OIDC] Discovery of " + p.Name + " failed: " + err.Error())
+		return nil, err
+	}
+	if doc.Issuer != p.Issuer {
+		return nil, errors.New("discovery issuer mismatch: " + doc.Issuer)
+	} else if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+
+	p.discovery = &doc
+	p.discoveryAt = time.Now()
+	return p.discovery, nil
+}
+
+// Get a signing key of the provider, the key set is refetched (at most once a minute) on unknown kids
+func (p *Provider) getKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysAt) > time.Minute
+	jwksURI := ""
+	if p.discovery != nil {
+		jwksURI = p.discovery.JWKSURI
+	}
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	} else if !stale || jwksURI == "" {
+		return nil, errors.New("unknown kid: " + kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwkSet
+	if err = doJSON(req, &set); err != nil {
+		logger.Error("[OIDC] Fetching keys of " + p.Name + " failed: " + err.Error())
+		return nil, err
+	}
+
+	keys := set.publicKeys()
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = time.Now()
+	p.mu.Unlock()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, errors.New("unknown kid: " + kid)
+	}
+	return key, nil
+}
+
+// Send the request and decode the JSON body, non 2xx statuses are errors (the body is still decoded)
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(resp.Body).Decode(v)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: status %d", req.Method, req.URL.Host+req.URL.Path, resp.StatusCode)
+	}
+	return decodeErr
+}
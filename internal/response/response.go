@@ -7,19 +7,57 @@ type Response struct {
 }
 
 type RegisterResponse struct {
-	UUID string `json:"UUID"`
+	UUID  string `json:"UUID"`
 	Email string `json:"Email"`
 }
 
 type LoginResponse struct {
-	UUID string `json:"UUID"`
-	Token string `json:"Token"`
+	UUID         string `json:"UUID"`
+	Token        string `json:"Token"`
+	RefreshToken string `json:"RefreshToken"`
+	ExpiresAt    int64  `json:"ExpiresAt"`
+}
+
+type TwoFactorChallengeResponse struct {
+	UUID              string `json:"UUID"`
+	TwoFactorRequired bool   `json:"TwoFactorRequired"`
+	ChallengeToken    string `json:"ChallengeToken"`
+	ExpiresAt         int64  `json:"ExpiresAt"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret          string `json:"Secret"`
+	ProvisioningURI string `json:"ProvisioningURI"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"RecoveryCodes"`
+}
+
+type OIDCLoginResponse struct {
+	AuthURL string `json:"AuthURL"`
+	State   string `json:"State"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"Token"`
+	RefreshToken string `json:"RefreshToken"`
+	ExpiresAt    int64  `json:"ExpiresAt"`
 }
 
 type ULIDResponse struct {
 	ULID string `json:"ULID"`
 }
 
+type TypeIDResponse struct {
+	PTID int `json:"PTID"`
+	CTID int `json:"CTID,omitempty"`
+}
+
+type TPIDResponse struct {
+	TPID string `json:"TPID"`
+}
+
 type UTIDResponse struct {
 	UTID string `json:"UTID"`
 }
@@ -27,7 +65,7 @@ type UTIDResponse struct {
 func New() *Response {
 	return &Response{
 		Status:  false,
-		Data:    nil, 
+		Data:    nil,
 		Message: nil,
 	}
 }
@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Shared secret of the RFC 6238 Appendix B SHA-1 test vectors
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 Appendix B, SHA-1. The RFC lists 8 digit codes, 6 digit codes are their last 6 digits.
+var rfcVectors = []struct {
+	unix int64
+	step int64
+	code string
+}{
+	{59, 0x1, "94287082"},
+	{1111111109, 0x23523EC, "07081804"},
+	{1111111111, 0x23523ED, "14050471"},
+	{1234567890, 0x273EF07, "89005924"},
+	{2000000000, 0x3F940AA, "69279037"},
+	{20000000000, 0x27BC86AA, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		at := time.Unix(v.unix, 0)
+		if step := Step(at); step != v.step {
+			t.Errorf("Step(%d) = %#x, want %#x", v.unix, step, v.step)
+		}
+
+		code, err := Code(rfcSecret, Step(at))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", v.unix, err)
+		}
+		if want := v.code[len(v.code)-Digits:]; code != want {
+			t.Errorf("Code(%d) = %s, want %s", v.unix, code, want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	upper, _ := Code("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 1)
+	lower, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || lower != upper {
+		t.Fatalf("Code with lower case secret = %s, %v, want %s", lower, err, upper)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("Code accepted an invalid secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	// Middle of the step of the 1111111111 vector
+	now := time.Unix(Step(time.Unix(1111111111, 0))*Period+Period/2, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		valid  bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.valid {
+				t.Fatalf("Validate = %v, want %v", ok, tt.valid)
+			}
+			if ok && step != current+tt.offset {
+				t.Fatalf("Validate matched step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateStepBoundaries(t *testing.T) {
+	code, _ := Code(rfcSecret, 100)
+
+	// Accepted from the first second of the step before to the last second of the step after
+	for _, tt := range []struct {
+		unix  int64
+		valid bool
+	}{
+		{98*Period + Period - 1, false},
+		{99 * Period, true},
+		{101*Period + Period - 1, true},
+		{102 * Period, false},
+	} {
+		if _, ok := Validate(rfcSecret, code, time.Unix(tt.unix, 0)); ok != tt.valid {
+			t.Errorf("Validate at %d = %v, want %v", tt.unix, ok, tt.valid)
+		}
+	}
+}
+
+func TestValidateMalformedCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Step(now))
+
+	if _, ok := Validate(rfcSecret, " "+code+" ", now); !ok {
+		t.Error("Validate rejected a code with surrounding spaces")
+	}
+	for _, bad := range []string{"", code[:Digits-1], code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Validate accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSender appends mails to a file (or stdout when Path is empty), for local development and tests
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (fs *FileSender) Send(to, subject, body string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var w io.Writer = os.Stdout
+	if fs.Path != "" {
+		f, err := os.OpenFile(fs.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, err := fmt.Fprintf(w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+	return err
+}
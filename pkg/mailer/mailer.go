@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"Fortune_Tracker_API/config"
+	"Fortune_Tracker_API/pkg/logger"
+	"errors"
+	"strings"
+)
+
+// Sender delivers a plain text mail to a single recipient
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+var Mail Sender
+
+// Set the mail sender according to MAIL_DRIVER (smtp, file or stdout)
+func InitMailer() {
+	switch driver := config.Viper.GetString("MAIL_DRIVER"); driver {
+	case "smtp":
+		Mail = &SMTPSender{
+			Host:     config.Viper.GetString("MAIL_SMTP_HOST"),
+			Port:     config.Viper.GetInt("MAIL_SMTP_PORT"),
+			User:     config.Viper.GetString("MAIL_SMTP_USER"),
+			Password: config.Viper.GetString("MAIL_SMTP_PASSWORD"),
+			From:     config.Viper.GetString("MAIL_FROM"),
+		}
+	case "file":
+		Mail = &FileSender{Path: config.Viper.GetString("MAIL_FILE_PATH")}
+	default:
+		if driver != "" && driver != "stdout" {
+			logger.Warn("[MAILER] Unknown MAIL_DRIVER: " + driver + ", falling back to stdout")
+		}
+		Mail = &FileSender{}
+	}
+}
+
+func Send(to, subject, body string) error {
+	if Mail == nil {
+		return errors.New("mailer is not initialized")
+	} else if strings.ContainsAny(to, "\r\n") {
+		return errors.New("invalid recipient address")
+	}
+	if err := Mail.Send(to, subject, body); err != nil {
+		logger.Error("[MAILER] " + err.Error())
+		return err
+	}
+
+	logger.Info("[MAILER] Sent mail \"" + subject + "\" to: " + to)
+	return nil
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPSender sends mails through an SMTP server with PLAIN authentication
+type SMTPSender struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+func (ss *SMTPSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if ss.User != "" {
+		auth = smtp.PlainAuth("", ss.User, ss.Password, ss.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + ss.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", ss.Host, ss.Port), auth, ss.From, []string{to}, []byte(msg))
+}
@@ -0,0 +1,123 @@
+-- MariaDB tables used by the API besides User and User_Info.
+-- Timestamps are stored as unix seconds.
+
+-- Login sessions, the SID is the jti claim of every access token of the session
+CREATE TABLE IF NOT EXISTS Session (
+    SID        CHAR(36) NOT NULL PRIMARY KEY,
+    UUID       CHAR(36) NOT NULL,
+    Created_At BIGINT   NOT NULL,
+    Revoked_At BIGINT   NULL,
+    INDEX (UUID)
+);
+
+-- Refresh tokens (sha256 hashed), rotated on every use
+CREATE TABLE IF NOT EXISTS Refresh_Token (
+    Token_Hash CHAR(64) NOT NULL PRIMARY KEY,
+    SID        CHAR(36) NOT NULL,
+    UUID       CHAR(36) NOT NULL,
+    Expires_At BIGINT   NOT NULL,
+    Used_At    BIGINT   NULL,
+    INDEX (SID),
+    INDEX (UUID)
+);
+
+-- Single-use password reset tokens (sha256 hashed)
+CREATE TABLE IF NOT EXISTS Password_Reset (
+    Token_Hash CHAR(64) NOT NULL PRIMARY KEY,
+    UUID       CHAR(36) NOT NULL,
+    Expires_At BIGINT   NOT NULL,
+    Used_At    BIGINT   NULL,
+    INDEX (UUID)
+);
+
+-- Email verification state, accounts created before this column exist start unverified
+ALTER TABLE User ADD COLUMN IF NOT EXISTS Email_Verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Email verification tokens (sha256 hashed), Email is the address being verified
+CREATE TABLE IF NOT EXISTS Email_Verification (
+    Token_Hash CHAR(64)     NOT NULL PRIMARY KEY,
+    UUID       CHAR(36)     NOT NULL,
+    Email      VARCHAR(255) NOT NULL,
+    Expires_At BIGINT       NOT NULL,
+    Used_At    BIGINT       NULL,
+    INDEX (UUID)
+);
+
+-- TOTP secrets, Enabled once the enrollment is confirmed with a first code
+CREATE TABLE IF NOT EXISTS User_TOTP (
+    UUID           CHAR(36)    NOT NULL PRIMARY KEY,
+    Secret         VARCHAR(64) NOT NULL,
+    Enabled        BOOLEAN     NOT NULL DEFAULT FALSE,
+    Last_Used_Step BIGINT      NOT NULL DEFAULT 0,
+    Created_At     BIGINT      NOT NULL
+);
+
+-- Two-factor recovery codes (sha256 hashed), each usable once
+CREATE TABLE IF NOT EXISTS Recovery_Code (
+    Code_Hash CHAR(64) NOT NULL PRIMARY KEY,
+    UUID      CHAR(36) NOT NULL,
+    Used_At   BIGINT   NULL,
+    INDEX (UUID)
+);
+
+-- Failed login counters per account (lowercased email) and per client IP
+CREATE TABLE IF NOT EXISTS Login_Failure (
+    Kind            VARCHAR(16)  NOT NULL,
+    Identifier      VARCHAR(255) NOT NULL,
+    Failures        INT          NOT NULL,
+    Last_Failure_At BIGINT       NOT NULL,
+    Locked_Until    BIGINT       NOT NULL DEFAULT 0,
+    PRIMARY KEY (Kind, Identifier)
+);
+
+-- Audit record of every login lockout
+CREATE TABLE IF NOT EXISTS Login_Lockout (
+    ID           BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    Kind         VARCHAR(16)  NOT NULL,
+    Identifier   VARCHAR(255) NOT NULL,
+    IP           VARCHAR(45)  NOT NULL,
+    Failures     INT          NOT NULL,
+    Locked_Until BIGINT       NOT NULL,
+    Created_At   BIGINT       NOT NULL,
+    INDEX (Kind, Identifier)
+);
+
+-- Pending OpenID Connect logins, the nonce and PKCE verifier never leave the server
+CREATE TABLE IF NOT EXISTS OIDC_State (
+    State_Hash    CHAR(64)    NOT NULL PRIMARY KEY,
+    Provider      VARCHAR(32) NOT NULL,
+    Nonce         VARCHAR(64) NOT NULL,
+    Code_Verifier VARCHAR(64) NOT NULL,
+    Expires_At    BIGINT      NOT NULL
+);
+
+-- External identities (provider + subject) linked to users
+CREATE TABLE IF NOT EXISTS User_Identity (
+    Provider   VARCHAR(32)  NOT NULL,
+    Subject    VARCHAR(255) NOT NULL,
+    UUID       CHAR(36)     NOT NULL,
+    Created_At BIGINT       NOT NULL,
+    PRIMARY KEY (Provider, Subject),
+    INDEX (UUID)
+);
+
+-- Personal API tokens (sha256 hashed), Scopes is space separated
+CREATE TABLE IF NOT EXISTS API_Token (
+    ATID         CHAR(36)      NOT NULL PRIMARY KEY,
+    UUID         CHAR(36)      NOT NULL,
+    Name         VARCHAR(255)  NOT NULL,
+    Token_Hash   CHAR(64)      NOT NULL UNIQUE,
+    Scopes       VARCHAR(4096) NOT NULL,
+    Created_At   BIGINT        NOT NULL,
+    Last_Used_At BIGINT        NULL,
+    Expires_At   BIGINT        NULL,
+    Revoked_At   BIGINT        NULL,
+    INDEX (UUID)
+);
+
+-- Device of each session, Last_Seen_At is updated (at most once a minute) when its tokens are used
+ALTER TABLE Session
+    ADD COLUMN IF NOT EXISTS Device_Name  VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS User_Agent   VARCHAR(512) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS IP           VARCHAR(45)  NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS Last_Seen_At BIGINT       NOT NULL DEFAULT 0;
@@ -14,6 +14,8 @@ import (
 var DB *mongo.Client
 var LedgerCollection *mongo.Collection
 var TransactionCollection *mongo.Collection
+var InvitationCollection *mongo.Collection
+var TemplateCollection *mongo.Collection
 
 func Connect() error {
 	// Get config values
@@ -38,6 +40,8 @@ func Connect() error {
 	// Set collection
 	LedgerCollection = DB.Database("Fortune_Tracker").Collection("Ledger")
 	TransactionCollection = DB.Database("Fortune_Tracker").Collection("Transaction")
+	InvitationCollection = DB.Database("Fortune_Tracker").Collection("Invitation")
+	TemplateCollection = DB.Database("Fortune_Tracker").Collection("Template")
 
 	logger.Info("[MONGODB] Successfully connected to MongoDB!")
 	return nil